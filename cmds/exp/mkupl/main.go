@@ -0,0 +1,82 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// mkupl wraps a raw UniversalPayload PE image in a FIT container, for use
+// with `kexec /upl`.
+//
+// Synopsis:
+//
+//	mkupl [OPTIONS...] PE-FILE FIT-FILE
+//
+// Description:
+//
+//	mkupl packages PE-FILE, an EDK2 UniversalPayload PE/COFF entry
+//	image, into FIT-FILE: a flattened devicetree describing its load
+//	address, entry point and (optionally compressed) data region,
+//	immediately followed by the payload bytes. The result is loadable
+//	by `kexec /upl` via pkg/boot/universalpayload.GetFdtInfo.
+//
+// Options:
+//
+//	-arch: value of the FIT image's "arch" property (default "x86_64")
+//	-os: value of the FIT image's "os" property (default "tianocore")
+//	-load: physical load address of the payload (default 0x800000)
+//	-entry: entry point address; must lie within the loaded image
+//	-compression: "none", "gzip", or "lzma" (default "none")
+//	-align: byte boundary the payload is aligned to after the DTB (default 4)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/boot/universalpayload"
+)
+
+var (
+	arch        = flag.String("arch", "x86_64", "value of the FIT image's 'arch' property")
+	osName      = flag.String("os", "tianocore", "value of the FIT image's 'os' property")
+	load        = flag.Uint64("load", 0x800000, "physical load address of the payload")
+	entry       = flag.Uint64("entry", 0, "entry point address")
+	compression = flag.String("compression", "none", "payload compression: none, gzip, or lzma")
+	align       = flag.Uint64("align", 4, "byte boundary the payload is aligned to after the DTB")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		log.Fatalf("usage: mkupl [OPTIONS...] PE-FILE FIT-FILE")
+	}
+	if err := run(flag.Arg(0), flag.Arg(1)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(peFile, fitFile string) error {
+	payload, err := os.ReadFile(peFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", peFile, err)
+	}
+
+	out, err := os.Create(fitFile)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", fitFile, err)
+	}
+	defer out.Close()
+
+	opts := universalpayload.PackOptions{
+		Load:        *load,
+		EntryStart:  *entry,
+		Arch:        *arch,
+		OS:          *osName,
+		Compression: *compression,
+		Align:       *align,
+	}
+	if err := universalpayload.PackFIT(out, payload, opts); err != nil {
+		return fmt.Errorf("failed to pack %s: %w", fitFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !race
+// +build !race
+
+package integration
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hugelgupf/vmtest/qemu"
+	"github.com/hugelgupf/vmtest/scriptvm"
+	"github.com/hugelgupf/vmtest/testtmp"
+	"github.com/u-root/mkuimage/uimage"
+)
+
+// TestKexecUplRISCV64 exercises `kexec /upl` on a RISC-V64 guest, covering
+// the auipc/ld trampoline built by the riscv64 trampolineBuilder.
+func TestKexecUplRISCV64(t *testing.T) {
+	qemu.SkipIfNotArch(t, qemu.ArchRISCV64)
+
+	initrd := filepath.Join(testtmp.TempDir(t), "initramfs.cpio")
+	vm := scriptvm.Start(t, "vm", "kexec /upl",
+		scriptvm.WithUimage(
+			uimage.WithCoveredCommands("github.com/u-root/u-root/cmds/core/kexec"),
+			uimage.WithFiles(fmt.Sprintf("%s:upl", os.Getenv("VMTEST_UPL"))),
+			uimage.WithCPIOOutput(initrd),
+		),
+		scriptvm.WithQEMUFn(
+			qemu.WithVMTimeout(time.Minute),
+			qemu.WithInitramfs(initrd),
+			qemu.P9Directory(filepath.Dir(initrd), "initramfs"),
+		),
+	)
+
+	if _, err := vm.Console.ExpectString("Shell>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Kill(); err != nil {
+		t.Errorf("Kill: %v", err)
+	}
+	_ = vm.Wait()
+}
+
+// TestKexecUplLoongArch64 exercises `kexec /upl` on a LoongArch64 guest,
+// covering the pcaddi/ld.d/jirl trampoline built by the loong64
+// trampolineBuilder.
+func TestKexecUplLoongArch64(t *testing.T) {
+	qemu.SkipIfNotArch(t, qemu.ArchLoong64)
+
+	initrd := filepath.Join(testtmp.TempDir(t), "initramfs.cpio")
+	vm := scriptvm.Start(t, "vm", "kexec /upl",
+		scriptvm.WithUimage(
+			uimage.WithCoveredCommands("github.com/u-root/u-root/cmds/core/kexec"),
+			uimage.WithFiles(fmt.Sprintf("%s:upl", os.Getenv("VMTEST_UPL"))),
+			uimage.WithCPIOOutput(initrd),
+		),
+		scriptvm.WithQEMUFn(
+			qemu.WithVMTimeout(time.Minute),
+			qemu.WithInitramfs(initrd),
+			qemu.P9Directory(filepath.Dir(initrd), "initramfs"),
+		),
+	)
+
+	if _, err := vm.Console.ExpectString("Shell>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Kill(); err != nil {
+		t.Errorf("Kill: %v", err)
+	}
+	_ = vm.Wait()
+}
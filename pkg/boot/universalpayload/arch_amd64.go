@@ -4,56 +4,15 @@
 
 package universalpayload
 
-import (
-	"bufio"
-	"encoding/binary"
-	"errors"
-	"fmt"
-	"os"
-	"regexp"
-	"strconv"
-)
+import "encoding/binary"
 
-var sysfsCPUInfoPath = "/proc/cpuinfo"
-var (
-	ErrCPUAddressConvert  = errors.New("failed to convert physical bits size")
-	ErrCPUAddressRead     = errors.New("failed to read 'address sizes'")
-	ErrCPUAddressNotFound = errors.New("'address sizes' information not found")
-)
+// amd64Trampoline builds the x86-64 trampoline blob.
+type amd64Trampoline struct{}
 
-// Get Physical Address size from sysfs node /proc/cpuinfo.
-// Both Physical and Virtual Address size will be prompted as format:
-// "address sizes	: 39 bits physical, 48 bits virtual"
-// Use regular expression to fetch the integer of Physical Address
-// size before "bits physical" keyword
-func getPhysicalAddressSizes() (uint8, error) {
-	file, err := os.Open(sysfsCPUInfoPath)
-	if err != nil {
-		return 0, fmt.Errorf("failed to open %s: %w", sysfsCPUInfoPath, err)
-	}
-	defer file.Close()
-
-	// Regular expression to match the address size line
-	re := regexp.MustCompile(`address sizes\s*:\s*(\d+)\s+bits physical,\s*(\d+)\s+bits virtual`)
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if match := re.FindStringSubmatch(line); match != nil {
-			// Convert the physical bits size to integer
-			physicalBits, err := strconv.ParseUint(match[1], 10, 8)
-			if err != nil {
-				return 0, errors.Join(ErrCPUAddressConvert, err)
-			}
-			return uint8(physicalBits), nil
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return 0, fmt.Errorf("%w: file: %s, err: %w", ErrCPUAddressRead, sysfsCPUInfoPath, err)
-	}
-
-	return 0, ErrCPUAddressNotFound
+// newTrampolineBuilder returns the trampolineBuilder for the running
+// GOARCH.
+func newTrampolineBuilder() trampolineBuilder {
+	return amd64Trampoline{}
 }
 
 // Constrcut trampoline code before jump to entry point of FIT image.
@@ -73,7 +32,7 @@ func getPhysicalAddressSizes() (uint8, error) {
 //	trampoline[32 - 39] : Top of stack address
 //	trampoline[40 - 47] : Base address of bootloader parameter
 //	trampoline[48 - 55] : Entry point of FIT image
-func constructTrampoline(buf []uint8, hobAddr uint64, entry uint64) []uint8 {
+func (amd64Trampoline) build(buf []uint8, hobAddr uint64, entry uint64) []uint8 {
 	loadStackAddress := []uint8{0x48, 0x8b, 0x05, 0x19, 0x00, 0x00, 0x00}
 	setStackAddress := []uint8{0x48, 0x89, 0xc4}
 	loadBootparameter := []uint8{0x48, 0x8b, 0x05, 0x17, 0x00, 0x00, 0x00}
@@ -6,10 +6,13 @@ package universalpayload
 
 import "encoding/binary"
 
-func getPhysicalAddressSizes() (uint8, error) {
-	// Return hardcode for arm64
-	// Please update to actual physical address size
-	return 44, nil
+// arm64Trampoline builds the arm64 trampoline blob.
+type arm64Trampoline struct{}
+
+// newTrampolineBuilder returns the trampolineBuilder for the running
+// GOARCH.
+func newTrampolineBuilder() trampolineBuilder {
+	return arm64Trampoline{}
 }
 
 // Constrcut trampoline code before jump to entry point of FIT image.
@@ -32,7 +35,7 @@ func getPhysicalAddressSizes() (uint8, error) {
 //	buf[36 - 39] : uint32(uint64(hobAddr)>>32))
 //	buf[40 - 43] : uint32(uint64(stackTop)&0xffffffff))
 //	buf[44 - 47] : uint32(uint64(stackTop)>>32))
-func constructTrampoline(buf []uint8, hobAddr uint64, entry uint64) []uint8 {
+func (arm64Trampoline) build(buf []uint8, hobAddr uint64, entry uint64) []uint8 {
 	appendUint32 := func(slice []uint8, value uint32) []uint8 {
 		tmpBytes := make([]uint8, 4)
 		binary.LittleEndian.PutUint32(tmpBytes, value)
@@ -0,0 +1,59 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package universalpayload
+
+import "encoding/binary"
+
+// loong64Trampoline builds the LoongArch64 trampoline blob.
+type loong64Trampoline struct{}
+
+// newTrampolineBuilder returns the trampolineBuilder for the running
+// GOARCH.
+func newTrampolineBuilder() trampolineBuilder {
+	return loong64Trampoline{}
+}
+
+// Constrcut trampoline code before jump to entry point of FIT image.
+// Due to lack of support to set value of General Purpose Registers in
+// kexec, bootloader parameter needs to be prepared in trampoline code.
+// Also stack is prepared in trampoline code snippet to ensure no data
+// leak.
+//
+// Trampoline code snippet is prepared as following:
+//
+//	buf[0 - 3]   : 0x1800000c - pcaddi t0, 0
+//	buf[4 - 7]   : 0x28c05183 - ld.d   sp, t0, 20
+//	buf[8 - 11]  : 0x28c07184 - ld.d   a0, t0, 28
+//	buf[12 - 15] : 0x28c0918d - ld.d   t1, t0, 36
+//	buf[16 - 19] : 0x4c0001a0 - jirl   zero, t1, 0
+//	buf[20 - 27] : Top of stack address
+//	buf[28 - 35] : Base address of bootloader parameter (HOB)
+//	buf[36 - 43] : Entry point of FIT image
+func (loong64Trampoline) build(buf []uint8, hobAddr uint64, entry uint64) []uint8 {
+	appendUint32 := func(slice []uint8, value uint32) []uint8 {
+		tmpBytes := make([]uint8, 4)
+		binary.LittleEndian.PutUint32(tmpBytes, value)
+		return append(slice, tmpBytes...)
+	}
+
+	buf = appendUint32(buf, 0x1800000c)
+	buf = appendUint32(buf, 0x28c05183)
+	buf = appendUint32(buf, 0x28c07184)
+	buf = appendUint32(buf, 0x28c0918d)
+	buf = appendUint32(buf, 0x4c0001a0)
+
+	stackTop := hobAddr + tmpStackTop
+	appendUint64 := func(slice []uint8, value uint64) []uint8 {
+		tmpBytes := make([]uint8, 8)
+		binary.LittleEndian.PutUint64(tmpBytes, value)
+		return append(slice, tmpBytes...)
+	}
+
+	buf = appendUint64(buf, stackTop)
+	buf = appendUint64(buf, hobAddr)
+	buf = appendUint64(buf, entry)
+
+	return buf
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package universalpayload
+
+import "encoding/binary"
+
+// riscv64Trampoline builds the RISC-V 64-bit trampoline blob.
+type riscv64Trampoline struct{}
+
+// newTrampolineBuilder returns the trampolineBuilder for the running
+// GOARCH.
+func newTrampolineBuilder() trampolineBuilder {
+	return riscv64Trampoline{}
+}
+
+// Constrcut trampoline code before jump to entry point of FIT image.
+// Due to lack of support to set value of General Purpose Registers in
+// kexec, bootloader parameter needs to be prepared in trampoline code.
+// Also stack is prepared in trampoline code snippet to ensure no data
+// leak.
+//
+// Trampoline code snippet is prepared as following:
+//
+//	buf[0 - 3]   : 0x00000297 - auipc t0, 0
+//	buf[4 - 7]   : 0x0142b103 - ld    sp, 20(t0)
+//	buf[8 - 11]  : 0x01c2b503 - ld    a0, 28(t0)
+//	buf[12 - 15] : 0x0242b303 - ld    t1, 36(t0)
+//	buf[16 - 19] : 0x00030067 - jr    t1
+//	buf[20 - 27] : Top of stack address
+//	buf[28 - 35] : Base address of bootloader parameter (HOB)
+//	buf[36 - 43] : Entry point of FIT image
+func (riscv64Trampoline) build(buf []uint8, hobAddr uint64, entry uint64) []uint8 {
+	appendUint32 := func(slice []uint8, value uint32) []uint8 {
+		tmpBytes := make([]uint8, 4)
+		binary.LittleEndian.PutUint32(tmpBytes, value)
+		return append(slice, tmpBytes...)
+	}
+
+	buf = appendUint32(buf, 0x00000297)
+	buf = appendUint32(buf, 0x0142b103)
+	buf = appendUint32(buf, 0x01c2b503)
+	buf = appendUint32(buf, 0x0242b303)
+	buf = appendUint32(buf, 0x00030067)
+
+	stackTop := hobAddr + tmpStackTop
+	appendUint64 := func(slice []uint8, value uint64) []uint8 {
+		tmpBytes := make([]uint8, 8)
+		binary.LittleEndian.PutUint64(tmpBytes, value)
+		return append(slice, tmpBytes...)
+	}
+
+	buf = appendUint64(buf, stackTop)
+	buf = appendUint64(buf, hobAddr)
+	buf = appendUint64(buf, entry)
+
+	return buf
+}
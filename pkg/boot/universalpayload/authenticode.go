@@ -0,0 +1,421 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package universalpayload
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+)
+
+// imageDirectoryEntrySecurity is the index of the Certificate Table entry in
+// a PE optional header's DataDirectory array. Unlike the other directory
+// entries, its VirtualAddress is a raw file offset rather than an RVA.
+const imageDirectoryEntrySecurity = 4
+
+// oidSignedData is the PKCS#7 SignedData content type
+// (1.2.840.113549.1.7.2).
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// oidSHA256, oidRSAEncryption identify the only digest/signature algorithm
+// combination this package currently verifies: SHA-256 with RSA PKCS#1 v1.5,
+// the combination produced by signtool for EDK2 UniversalPayload images.
+var (
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// Errors returned by Authenticode verification.
+var (
+	ErrAuthenticodeOutOfBound         = errors.New("security directory out of bounds")
+	ErrAuthenticodeSignatureMissing   = errors.New("FIT payload has no embedded Authenticode signature")
+	ErrAuthenticodeMalformedSignature = errors.New("malformed Authenticode/PKCS#7 signature")
+	ErrAuthenticodeNotSignedData      = errors.New("PKCS#7 ContentInfo is not SignedData")
+	ErrAuthenticodeUnsupportedAlgo    = errors.New("unsupported Authenticode digest/signature algorithm")
+	ErrAuthenticodeDigestMismatch     = errors.New("Authenticode PE hash does not match signed digest")
+	ErrAuthenticodeUntrustedChain     = errors.New("Authenticode signing certificate does not chain to a trusted root")
+)
+
+// VerifyOptions opts a FIT load in to Authenticode verification of the
+// embedded PE payload before it is relocated and jumped to. When nil (the
+// default), no verification is performed.
+type VerifyOptions struct {
+	// Roots is the set of trust anchors the signing certificate must
+	// chain to.
+	Roots *x509.CertPool
+}
+
+// VerifyFIT verifies the Authenticode signature embedded in the PE payload
+// referenced by fdtLoad, against roots. data is the full FIT image; the
+// payload region is decompressed internally according to
+// fdtLoad.Compression before hashing, so callers that only need to verify
+// a FIT (without loading it) do not need decompressPayload to be exported.
+// It returns a wrapped error if the payload cannot be decompressed, or if
+// its signature is absent, malformed, or does not chain to a trusted root.
+func VerifyFIT(fdtLoad *FdtLoad, data []byte, roots *x509.CertPool) error {
+	start := fdtLoad.DataOffset
+	end := fdtLoad.DataOffset + fdtLoad.DataSize
+	if uint64(end) > uint64(len(data)) {
+		return ErrAuthenticodeOutOfBound
+	}
+
+	peData, err := decompressPayload(data[start:end], fdtLoad.Compression)
+	if err != nil {
+		return err
+	}
+
+	return verifyAuthenticodePE(peData, roots)
+}
+
+// verifyAuthenticodePE verifies the Authenticode signature embedded in
+// peData, an already-decompressed PE image, against roots. It returns a
+// wrapped error if the signature is absent, malformed, or does not chain
+// to a trusted root.
+func verifyAuthenticodePE(peData []byte, roots *x509.CertPool) error {
+	peFile, err := pe.NewFile(bytes.NewReader(peData))
+	if err != nil {
+		return ErrPeFailToCreatePeFile
+	}
+	defer peFile.Close()
+
+	secOffset, secSize, err := securityDirectory(peFile)
+	if err != nil {
+		return err
+	}
+	if secSize == 0 {
+		return ErrAuthenticodeSignatureMissing
+	}
+	if uint64(secOffset)+uint64(secSize) > uint64(len(peData)) {
+		return ErrAuthenticodeOutOfBound
+	}
+
+	// WIN_CERTIFICATE: dwLength(4) + wRevision(2) + wCertificateType(2),
+	// followed by the bCertificate PKCS#7 SignedData blob.
+	winCert := peData[secOffset : secOffset+secSize]
+	if len(winCert) < 8 {
+		return ErrAuthenticodeMalformedSignature
+	}
+
+	sd, err := parsePKCS7SignedData(winCert[8:])
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if err := computeAuthenticodeHash(h, peFile, peData); err != nil {
+		return err
+	}
+
+	return sd.verify(h.Sum(nil), roots)
+}
+
+// securityDirectory returns the file offset and size of a PE image's
+// Certificate Table (IMAGE_DIRECTORY_ENTRY_SECURITY), for either a PE32 or
+// PE32+ optional header.
+func securityDirectory(peFile *pe.File) (offset uint32, size uint32, err error) {
+	switch oh := peFile.OptionalHeader.(type) {
+	case *pe.OptionalHeader64:
+		d := oh.DataDirectory[imageDirectoryEntrySecurity]
+		return d.VirtualAddress, d.Size, nil
+	case *pe.OptionalHeader32:
+		d := oh.DataDirectory[imageDirectoryEntrySecurity]
+		return d.VirtualAddress, d.Size, nil
+	default:
+		return 0, 0, ErrPeUnsupportedPeHeader
+	}
+}
+
+// peHeaderOffsets locates the file offset of the optional header's CheckSum
+// field and of its Certificate Table data directory entry, by walking the
+// DOS/COFF header the same way the loader does, since debug/pe does not
+// expose these as raw offsets.
+func peHeaderOffsets(data []byte) (checksumOffset, securityDirOffset int, err error) {
+	if len(data) < 0x40 {
+		return 0, 0, ErrPeUnsupportedPeHeader
+	}
+
+	peOffset := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	if peOffset < 0 || peOffset+24 > len(data) {
+		return 0, 0, ErrPeUnsupportedPeHeader
+	}
+	if string(data[peOffset:peOffset+4]) != "PE\x00\x00" {
+		return 0, 0, ErrPeUnsupportedPeHeader
+	}
+
+	// IMAGE_FILE_HEADER is 20 bytes.
+	optionalHeaderOffset := peOffset + 4 + 20
+	if optionalHeaderOffset+2 > len(data) {
+		return 0, 0, ErrPeUnsupportedPeHeader
+	}
+
+	magic := binary.LittleEndian.Uint16(data[optionalHeaderOffset:])
+
+	// CheckSum sits at the same offset in both PE32 and PE32+: the extra
+	// 4-byte BaseOfData field in PE32 exactly offsets the narrower 4-byte
+	// (vs 8-byte) ImageBase field.
+	checksumOffset = optionalHeaderOffset + 64
+
+	var dataDirectoryOffset int
+	switch magic {
+	case 0x10b: // PE32
+		dataDirectoryOffset = optionalHeaderOffset + 96
+	case 0x20b: // PE32+
+		dataDirectoryOffset = optionalHeaderOffset + 112
+	default:
+		return 0, 0, ErrPeUnsupportedPeHeader
+	}
+
+	securityDirOffset = dataDirectoryOffset + imageDirectoryEntrySecurity*8
+	if securityDirOffset+8 > len(data) {
+		return 0, 0, ErrPeUnsupportedPeHeader
+	}
+
+	return checksumOffset, securityDirOffset, nil
+}
+
+// computeAuthenticodeHash computes the Authenticode PE hash of the PE image
+// in data by hashing the whole file, in file-offset order, except the
+// CheckSum field, the Certificate Table data directory entry, and the
+// Certificate Table (WIN_CERTIFICATE) itself.
+func computeAuthenticodeHash(h hash.Hash, peFile *pe.File, data []byte) error {
+	checksumOffset, securityDirOffset, err := peHeaderOffsets(data)
+	if err != nil {
+		return err
+	}
+
+	secOffset, secSize, err := securityDirectory(peFile)
+	if err != nil {
+		return err
+	}
+
+	h.Write(data[:checksumOffset])
+	h.Write(data[checksumOffset+4 : securityDirOffset])
+
+	afterDirEntry := securityDirOffset + 8
+	if uint32(afterDirEntry) > secOffset {
+		return ErrAuthenticodeMalformedSignature
+	}
+	h.Write(data[afterDirEntry:secOffset])
+
+	afterCertTable := secOffset + secSize
+	if int(afterCertTable) < len(data) {
+		h.Write(data[afterCertTable:])
+	}
+
+	return nil
+}
+
+// pkcs7ContentInfo is the outer ContentInfo wrapper of a WIN_CERTIFICATE's
+// bCertificate blob (RFC 2315 section 7).
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+// pkcs7IssuerAndSerial identifies the signing certificate referenced by a
+// SignerInfo.
+type pkcs7IssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// pkcs7Attribute is a single PKCS#9 Attribute (Type/Values pair) found in a
+// SignerInfo's authenticatedAttributes set.
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// pkcs7SignerInfo is a single RFC 2315 SignerInfo.
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// pkcs7SignedData is an RFC 2315 SignedData, trimmed to the fields
+// Authenticode verification needs.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      asn1.RawValue
+	Certificates     []asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             []asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+// parsePKCS7SignedData decodes a DER-encoded PKCS#7 ContentInfo and returns
+// its SignedData content.
+func parsePKCS7SignedData(der []byte) (*pkcs7SignedData, error) {
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, errors.Join(ErrAuthenticodeMalformedSignature, err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, ErrAuthenticodeNotSignedData
+	}
+
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, errors.Join(ErrAuthenticodeMalformedSignature, err)
+	}
+	return &sd, nil
+}
+
+// certificates parses the X.509 certificates embedded in the SignedData's
+// certificate set.
+func (sd *pkcs7SignedData) certificates() ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(sd.Certificates))
+	for _, raw := range sd.Certificates {
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, errors.Join(ErrAuthenticodeMalformedSignature, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// verify checks that peHash matches the digest signed by this SignedData,
+// and that the signing certificate chains to roots.
+func (sd *pkcs7SignedData) verify(peHash []byte, roots *x509.CertPool) error {
+	if len(sd.SignerInfos) == 0 {
+		return ErrAuthenticodeSignatureMissing
+	}
+
+	certs, err := sd.certificates()
+	if err != nil {
+		return err
+	}
+
+	// Authenticode embeds exactly one signer; only the first is verified.
+	si := sd.SignerInfos[0]
+
+	signer := findCertBySerial(certs, si.IssuerAndSerialNumber.Serial)
+	if signer == nil {
+		return ErrAuthenticodeMalformedSignature
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		if !c.Equal(signer) {
+			intermediates.AddCert(c)
+		}
+	}
+	if _, err := signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageAny},
+	}); err != nil {
+		return errors.Join(ErrAuthenticodeUntrustedChain, err)
+	}
+
+	sigAlgo, err := signatureAlgorithmFor(si.DigestAlgorithm.Algorithm, si.DigestEncryptionAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	if len(si.AuthenticatedAttributes.Bytes) == 0 {
+		// peHash is already the content digest Authenticode signs in this
+		// case, so it must be verified directly rather than through
+		// CheckSignature, which would hash it a second time.
+		rsaKey, ok := signer.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: signing certificate has non-RSA public key %T", ErrAuthenticodeUnsupportedAlgo, signer.PublicKey)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, peHash, si.EncryptedDigest); err != nil {
+			return errors.Join(ErrAuthenticodeDigestMismatch, err)
+		}
+		return nil
+	}
+
+	signedDigest, signedAttrs, err := decodeAuthenticatedAttributes(si.AuthenticatedAttributes)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(signedDigest, peHash) {
+		return ErrAuthenticodeDigestMismatch
+	}
+
+	if err := signer.CheckSignature(sigAlgo, signedAttrs, si.EncryptedDigest); err != nil {
+		return errors.Join(ErrAuthenticodeDigestMismatch, err)
+	}
+	return nil
+}
+
+// decodeAuthenticatedAttributes extracts the messageDigest attribute from a
+// SignerInfo's authenticatedAttributes, and returns the attribute set
+// re-tagged as a universal SET OF rather than the [0] IMPLICIT form it is
+// embedded in, since that is what Authenticode actually signs.
+func decodeAuthenticatedAttributes(attrs asn1.RawValue) ([]byte, []byte, error) {
+	var messageDigest []byte
+	rest := attrs.Bytes
+	for len(rest) > 0 {
+		var attr pkcs7Attribute
+		tail, err := asn1.Unmarshal(rest, &attr)
+		if err != nil {
+			return nil, nil, errors.Join(ErrAuthenticodeMalformedSignature, err)
+		}
+		rest = tail
+
+		if attr.Type.Equal(oidMessageDigest) && len(attr.Values) == 1 {
+			var digest []byte
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &digest); err != nil {
+				return nil, nil, errors.Join(ErrAuthenticodeMalformedSignature, err)
+			}
+			messageDigest = digest
+		}
+	}
+	if messageDigest == nil {
+		return nil, nil, ErrAuthenticodeMalformedSignature
+	}
+
+	signedAttrs := append([]byte(nil), attrs.FullBytes...)
+	if len(signedAttrs) == 0 {
+		return nil, nil, ErrAuthenticodeMalformedSignature
+	}
+	signedAttrs[0] = 0x31 // retag: universal, constructed SET OF
+
+	return messageDigest, signedAttrs, nil
+}
+
+// findCertBySerial returns the certificate in certs whose serial number
+// matches serial, or nil if none does.
+func findCertBySerial(certs []*x509.Certificate, serial *big.Int) *x509.Certificate {
+	for _, c := range certs {
+		if c.SerialNumber != nil && serial != nil && c.SerialNumber.Cmp(serial) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// signatureAlgorithmFor maps an Authenticode digest/encryption OID pair to
+// the x509.SignatureAlgorithm used to verify it. Only SHA-256 with RSA is
+// currently supported, the combination EDK2/signtool produce for
+// UniversalPayload images.
+func signatureAlgorithmFor(digest, encryption asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
+	switch {
+	case digest.Equal(oidSHA256) && encryption.Equal(oidRSAEncryption):
+		return x509.SHA256WithRSA, nil
+	default:
+		return 0, fmt.Errorf("%w: digest %v, encryption %v", ErrAuthenticodeUnsupportedAlgo, digest, encryption)
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package universalpayload
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// oidData is the PKCS#7 "data" content type (1.2.840.113549.1.7.1), used
+// as the (unexamined) inner ContentInfo of the test fixtures below.
+var oidData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+
+// testSigner is a self-signed Authenticode-style signing certificate and
+// its private key, generated once per test.
+type testSigner struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+	der  []byte
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "universalpayload test signer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate: %v", err)
+	}
+
+	return &testSigner{key: key, cert: cert, der: der}
+}
+
+func (s *testSigner) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.cert)
+	return pool
+}
+
+// derWrap returns content wrapped in a DER tag-length-value header, for
+// constructing ASN.1 structures asn1.Marshal has no direct support for
+// (here, the [0] EXPLICIT wrapper around a PKCS#7 ContentInfo's content).
+func derWrap(tag byte, content []byte) []byte {
+	var length []byte
+	switch {
+	case len(content) < 0x80:
+		length = []byte{byte(len(content))}
+	default:
+		var n []byte
+		for v := len(content); v > 0; v >>= 8 {
+			n = append([]byte{byte(v)}, n...)
+		}
+		length = append([]byte{0x80 | byte(len(n))}, n...)
+	}
+	out := make([]byte, 0, 1+len(length)+len(content))
+	out = append(out, tag)
+	out = append(out, length...)
+	out = append(out, content...)
+	return out
+}
+
+// buildMinimalPEHeader returns a minimal, section-less PE32+ (amd64) image
+// whose Certificate Table data directory points at [secOffset,
+// secOffset+secSize). It is just enough for pe.NewFile and
+// computeAuthenticodeHash to work: the hash itself never looks past the
+// header fields it explicitly reads.
+func buildMinimalPEHeader(secOffset, secSize uint32) []byte {
+	var buf bytes.Buffer
+
+	dos := make([]byte, 0x40)
+	dos[0], dos[1] = 'M', 'Z'
+	binary.LittleEndian.PutUint32(dos[0x3c:], uint32(len(dos)))
+	buf.Write(dos)
+
+	buf.WriteString("PE\x00\x00")
+
+	const numDataDirectories = 16
+	const optionalHeaderSize = 112 + numDataDirectories*8
+
+	binary.Write(&buf, binary.LittleEndian, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     0,
+		SizeOfOptionalHeader: optionalHeaderSize,
+		Characteristics:      pe.IMAGE_FILE_EXECUTABLE_IMAGE,
+	})
+
+	oh := pe.OptionalHeader64{
+		Magic:               0x20b,
+		ImageBase:           0x140000000,
+		SectionAlignment:    0x1000,
+		FileAlignment:       0x200,
+		SizeOfImage:         0x1000,
+		SizeOfHeaders:       uint32(buf.Len()) + uint32(binary.Size(pe.OptionalHeader64{})),
+		NumberOfRvaAndSizes: numDataDirectories,
+	}
+	oh.DataDirectory[imageDirectoryEntrySecurity] = pe.DataDirectory{VirtualAddress: secOffset, Size: secSize}
+	binary.Write(&buf, binary.LittleEndian, oh)
+
+	return buf.Bytes()
+}
+
+// signPEOptions controls how signPE tampers with an otherwise valid
+// Authenticode signature, to exercise verify's failure paths.
+type signPEOptions struct {
+	useAuthenticatedAttributes bool
+	corruptPEAfterSigning      bool
+	wrongSerial                bool
+	digestAlgo                 asn1.ObjectIdentifier
+}
+
+// signPE assembles a minimal PE image signed by signer, returning the full
+// image bytes with the PKCS#7 SignedData blob appended as its Certificate
+// Table. It performs the same Authenticode hash computation
+// computeAuthenticodeHash does, so the fixture it builds is only as good
+// as that logic is independently trustworthy: the WIN_CERTIFICATE layout,
+// the DataDirectory bookkeeping, and (when useAuthenticatedAttributes is
+// set) the authenticatedAttributes [0] IMPLICIT re-tagging are all
+// produced by hand here rather than reused from authenticode.go.
+func signPE(t *testing.T, signer *testSigner, opts signPEOptions) []byte {
+	t.Helper()
+
+	digestAlgo := opts.digestAlgo
+	if digestAlgo == nil {
+		digestAlgo = oidSHA256
+	}
+
+	// RSA PKCS#1 v1.5 signatures are always exactly k bytes (k = modulus
+	// size), regardless of message, so the final SignedData's length can
+	// be computed with a placeholder signature of the right size before
+	// the real Authenticode hash (which covers everything up to the
+	// Certificate Table) is known.
+	sigSize := signer.key.Size()
+	placeholder := make([]byte, sigSize)
+
+	innerContent, err := asn1.Marshal(struct{ ContentType asn1.ObjectIdentifier }{oidData})
+	if err != nil {
+		t.Fatalf("asn1.Marshal(inner ContentInfo): %v", err)
+	}
+
+	serial := signer.cert.SerialNumber
+	if opts.wrongSerial {
+		serial = new(big.Int).Add(serial, big.NewInt(1))
+	}
+
+	buildSignedData := func(encryptedDigest []byte, authAttrs asn1.RawValue) []byte {
+		sd := pkcs7SignedData{
+			Version:          1,
+			DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestAlgo}},
+			ContentInfo:      asn1.RawValue{FullBytes: innerContent},
+			Certificates:     []asn1.RawValue{{FullBytes: signer.der}},
+			SignerInfos: []pkcs7SignerInfo{{
+				Version: 1,
+				IssuerAndSerialNumber: pkcs7IssuerAndSerial{
+					Issuer: asn1.RawValue{FullBytes: signer.cert.RawIssuer},
+					Serial: serial,
+				},
+				DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: digestAlgo},
+				AuthenticatedAttributes:   authAttrs,
+				DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption},
+				EncryptedDigest:           encryptedDigest,
+			}},
+		}
+		sdBytes, err := asn1.Marshal(sd)
+		if err != nil {
+			t.Fatalf("asn1.Marshal(SignedData): %v", err)
+		}
+		ci := pkcs7ContentInfo{
+			ContentType: oidSignedData,
+			Content:     asn1.RawValue{FullBytes: derWrap(0xa0, sdBytes)},
+		}
+		ciBytes, err := asn1.Marshal(ci)
+		if err != nil {
+			t.Fatalf("asn1.Marshal(ContentInfo): %v", err)
+		}
+		return ciBytes
+	}
+
+	// authAttrsFor builds the (possibly empty) AuthenticatedAttributes
+	// field for a given messageDigest value, along with the bytes that
+	// are actually signed: either the PE hash directly, or the
+	// authenticatedAttributes set re-tagged from [0] IMPLICIT to a
+	// universal SET OF, the same transform decodeAuthenticatedAttributes
+	// undoes on the verify side.
+	authAttrsFor := func(peHash []byte) (asn1.RawValue, []byte) {
+		if !opts.useAuthenticatedAttributes {
+			return asn1.RawValue{}, peHash
+		}
+		digestOctet, err := asn1.Marshal(peHash)
+		if err != nil {
+			t.Fatalf("asn1.Marshal(messageDigest): %v", err)
+		}
+		attrs := []pkcs7Attribute{{
+			Type:   oidMessageDigest,
+			Values: []asn1.RawValue{{FullBytes: digestOctet}},
+		}}
+		universalSet, err := asn1.MarshalWithParams(attrs, "set")
+		if err != nil {
+			t.Fatalf("asn1.MarshalWithParams(attrs, set): %v", err)
+		}
+		implicitSet := append([]byte(nil), universalSet...)
+		implicitSet[0] = 0xa0 // [0] IMPLICIT, constructed
+		return asn1.RawValue{FullBytes: implicitSet}, universalSet
+	}
+
+	// First pass: figure out the final Certificate Table size using a
+	// placeholder signature of the correct length, so the PE header's
+	// DataDirectory entry (which the hash computation reads to find
+	// where the header ends) can be finalized before hashing.
+	placeholderAuthAttrs, _ := authAttrsFor(make([]byte, sha256.Size))
+	placeholderSignedData := buildSignedData(placeholder, placeholderAuthAttrs)
+	secSize := uint32(8 + len(placeholderSignedData))
+
+	secOffset := uint32(len(buildMinimalPEHeader(0, 0)))
+	peHeader := buildMinimalPEHeader(secOffset, secSize)
+
+	peData := append(append([]byte(nil), peHeader...), make([]byte, secSize)...)
+
+	peFile, err := pe.NewFile(bytes.NewReader(peData))
+	if err != nil {
+		t.Fatalf("pe.NewFile: %v", err)
+	}
+	defer peFile.Close()
+
+	h := sha256.New()
+	if err := computeAuthenticodeHash(h, peFile, peData); err != nil {
+		t.Fatalf("computeAuthenticodeHash: %v", err)
+	}
+	peHash := h.Sum(nil)
+
+	// What actually gets signed differs by case: with authenticatedAttributes
+	// present, Authenticode signs the hash of the (re-tagged) attribute set;
+	// without them, it signs the PE hash directly, so signedBytes is already
+	// the digest and must not be hashed again.
+	authAttrs, signedBytes := authAttrsFor(peHash)
+	hashed := signedBytes
+	if opts.useAuthenticatedAttributes {
+		digest := sha256.Sum256(signedBytes)
+		hashed = digest[:]
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, hashed)
+	if err != nil {
+		t.Fatalf("rsa.SignPKCS1v15: %v", err)
+	}
+	if len(signature) != sigSize {
+		t.Fatalf("signature length = %d, want %d (RSA signatures must be constant-size for the pre-sized Certificate Table to line up)", len(signature), sigSize)
+	}
+
+	signedData := buildSignedData(signature, authAttrs)
+	if uint32(8+len(signedData)) != secSize {
+		t.Fatalf("final SignedData size %d != placeholder size %d", 8+len(signedData), secSize)
+	}
+
+	if opts.corruptPEAfterSigning {
+		peData[0x80] ^= 0xff
+	}
+
+	winCert := make([]byte, 8)
+	binary.LittleEndian.PutUint32(winCert[0:], secSize)
+	binary.LittleEndian.PutUint16(winCert[4:], 0x0200) // WIN_CERT_REVISION_2_0
+	binary.LittleEndian.PutUint16(winCert[6:], 0x0002) // WIN_CERT_TYPE_PKCS_SIGNED_DATA
+	copy(peData[secOffset:], winCert)
+	copy(peData[secOffset+8:], signedData)
+
+	return peData
+}
+
+func TestVerifyFITAuthenticode(t *testing.T) {
+	signer := newTestSigner(t)
+	untrusted := newTestSigner(t)
+
+	for _, tt := range []struct {
+		name        string
+		signer      *testSigner
+		opts        signPEOptions
+		roots       *x509.CertPool
+		wantErr     error
+		wantErrText string
+	}{
+		{
+			name:   "valid signature, no authenticated attributes",
+			signer: signer,
+			roots:  signer.roots(),
+		},
+		{
+			name:   "valid signature, with authenticated attributes",
+			signer: signer,
+			opts:   signPEOptions{useAuthenticatedAttributes: true},
+			roots:  signer.roots(),
+		},
+		{
+			name:    "PE modified after signing is rejected",
+			signer:  signer,
+			opts:    signPEOptions{corruptPEAfterSigning: true},
+			roots:   signer.roots(),
+			wantErr: ErrAuthenticodeDigestMismatch,
+		},
+		{
+			name:    "authenticated attributes digest mismatch after tampering",
+			signer:  signer,
+			opts:    signPEOptions{useAuthenticatedAttributes: true, corruptPEAfterSigning: true},
+			roots:   signer.roots(),
+			wantErr: ErrAuthenticodeDigestMismatch,
+		},
+		{
+			name:    "signing certificate does not chain to the supplied roots",
+			signer:  untrusted,
+			roots:   signer.roots(),
+			wantErr: ErrAuthenticodeUntrustedChain,
+		},
+		{
+			name:    "unresolvable signer serial number",
+			signer:  signer,
+			opts:    signPEOptions{wrongSerial: true},
+			roots:   signer.roots(),
+			wantErr: ErrAuthenticodeMalformedSignature,
+		},
+		{
+			name:    "unsupported digest/signature algorithm",
+			signer:  signer,
+			opts:    signPEOptions{digestAlgo: asn1.ObjectIdentifier{1, 2, 3, 4}},
+			roots:   signer.roots(),
+			wantErr: ErrAuthenticodeUnsupportedAlgo,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			peData := signPE(t, tt.signer, tt.opts)
+
+			err := verifyAuthenticodePE(peData, tt.roots)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("verifyAuthenticodePE(): %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verifyAuthenticodePE() err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyFITAuthenticodeSignatureMissing(t *testing.T) {
+	peHeader := buildMinimalPEHeader(0, 0)
+
+	if err := verifyAuthenticodePE(peHeader, x509.NewCertPool()); !errors.Is(err, ErrAuthenticodeSignatureMissing) {
+		t.Fatalf("verifyAuthenticodePE() err = %v, want %v", err, ErrAuthenticodeSignatureMissing)
+	}
+}
+
+func TestVerifyFITDecompresses(t *testing.T) {
+	signer := newTestSigner(t)
+	peData := signPE(t, signer, signPEOptions{})
+
+	compressed, err := compressPayload(peData, "gzip")
+	if err != nil {
+		t.Fatalf("compressPayload: %v", err)
+	}
+
+	data := append([]byte{0xaa, 0xbb, 0xcc}, compressed...)
+	fdtLoad := &FdtLoad{
+		DataOffset:  3,
+		DataSize:    uint32(len(compressed)),
+		Compression: "gzip",
+	}
+
+	if err := VerifyFIT(fdtLoad, data, signer.roots()); err != nil {
+		t.Fatalf("VerifyFIT(): %v", err)
+	}
+}
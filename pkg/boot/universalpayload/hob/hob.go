@@ -0,0 +1,328 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hob builds a Hand-Off Block (HOB) list, the structure EDK2's
+// UniversalPayload uses to carry boot-time state (memory map, CPU
+// capabilities, firmware volumes, ...) from the payload that constructs it
+// to the payload that consumes it. See the Platform Initialization (PI)
+// Specification, volume 3, section 5 for the on-disk layout this package
+// reproduces.
+package hob
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// HOB types, see PI spec volume 3, section 5.2.
+const (
+	typeHandoff            uint16 = 0x0001
+	typeMemoryAllocation   uint16 = 0x0002
+	typeResourceDescriptor uint16 = 0x0003
+	typeGUIDExtension      uint16 = 0x0004
+	typeFV                 uint16 = 0x0005
+	typeCPU                uint16 = 0x0006
+	typeEndOfHobList       uint16 = 0xffff
+)
+
+// hobAlign is the alignment EDK2 expects between consecutive HOBs.
+const hobAlign = 8
+
+// Errors returned by Builder.
+var (
+	ErrPayloadTooLarge = errors.New("hob: payload too large to encode in a HOB length field")
+	ErrNotFinalized    = errors.New("hob: Finalize called twice")
+)
+
+// genericHeader is EFI_HOB_GENERIC_HEADER, the header every HOB starts
+// with.
+type genericHeader struct {
+	HobType   uint16
+	HobLength uint16
+	Reserved  uint32
+}
+
+// handoffInfoTable is EFI_HOB_HANDOFF_INFO_TABLE, the PHIT HOB that always
+// begins a HOB list.
+type handoffInfoTable struct {
+	Header              genericHeader
+	Version             uint32
+	BootMode            uint32
+	EfiMemoryTop        uint64
+	EfiMemoryBottom     uint64
+	EfiFreeMemoryTop    uint64
+	EfiFreeMemoryBottom uint64
+	EfiEndOfHobList     uint64
+}
+
+// cpuHob is the body of EFI_HOB_CPU, following the generic header.
+type cpuHob struct {
+	SizeOfMemorySpace uint8
+	SizeOfIoSpace     uint8
+	Reserved          [6]uint8
+}
+
+// memoryAllocationHob is the body of EFI_HOB_MEMORY_ALLOCATION, following
+// the generic header, specialized to the anonymous-allocation case (a
+// zeroed EFI_GUID Name field) used by UniversalPayload.
+type memoryAllocationHob struct {
+	Name           [16]byte
+	MemoryBaseAddr uint64
+	MemoryLength   uint64
+	MemoryType     uint32
+	Reserved       [4]uint8
+}
+
+// resourceDescriptorHob is the body of EFI_HOB_RESOURCE_DESCRIPTOR, following
+// the generic header.
+type resourceDescriptorHob struct {
+	Owner             [16]byte
+	ResourceType      uint32
+	ResourceAttribute uint32
+	PhysicalStart     uint64
+	ResourceLength    uint64
+}
+
+// firmwareVolumeHob is the body of EFI_HOB_FIRMWARE_VOLUME, following the
+// generic header.
+type firmwareVolumeHob struct {
+	BaseAddr uint64
+	Length   uint64
+}
+
+// gUniversalPayloadSerialPortInfoGuid identifies the
+// UNIVERSAL_PAYLOAD_SERIAL_PORT_INFO GUID HOB, as defined by the Universal
+// Payload specification.
+var gUniversalPayloadSerialPortInfoGuid = [16]byte{
+	0xEB, 0x9D, 0x2D, 0xAA, 0x2D, 0x88, 0x11, 0xD3,
+	0x9A, 0x16, 0x00, 0x90, 0x27, 0x3F, 0xC1, 0x4D,
+}
+
+// serialPortInfo is UNIVERSAL_PAYLOAD_SERIAL_PORT_INFO.
+type serialPortInfo struct {
+	Header struct {
+		Revision uint8
+		Reserved uint8
+		Length   uint16
+	}
+	UseMmio        uint8
+	RegisterStride uint8
+	Reserved       [2]uint8
+	BaudRate       uint32
+	RegisterBase   uint64
+}
+
+// Builder accumulates HOBs into a single byte stream, patterned after
+// EDK2's HobLib: construct, append zero or more HOBs, terminate with
+// EndOfHobList, then Finalize to get the bytes and have the PHIT HOB's
+// free-memory bookkeeping updated to reflect the list's final size.
+//
+// A Builder is not safe for concurrent use.
+type Builder struct {
+	buf bytes.Buffer
+
+	memoryBottom     uint64
+	memoryTop        uint64
+	freeMemoryBottom uint64
+
+	finalized bool
+}
+
+// NewBuilder creates a Builder seeded with a PHIT (EFI_HOB_HANDOFF_INFO_TABLE)
+// HOB describing the memory range [memoryBottom, memoryTop), with
+// freeMemoryBottom being the address the HOB list itself will be placed at.
+// Subsequent Add* calls append HOBs directly after the PHIT HOB, the same
+// way EDK2's HobLib grows a HOB list in place; Finalize patches the PHIT
+// HOB's EfiFreeMemoryBottom and EfiEndOfHobList fields to point past the
+// last HOB written.
+func NewBuilder(memoryBottom, memoryTop, freeMemoryBottom uint64) *Builder {
+	b := &Builder{
+		memoryBottom:     memoryBottom,
+		memoryTop:        memoryTop,
+		freeMemoryBottom: freeMemoryBottom,
+	}
+
+	phit := handoffInfoTable{
+		Header: genericHeader{
+			HobType:   typeHandoff,
+			HobLength: uint16(binary.Size(handoffInfoTable{})),
+		},
+		Version:             0x00010000,
+		BootMode:            0, // BOOT_WITH_FULL_CONFIGURATION
+		EfiMemoryTop:        memoryTop,
+		EfiMemoryBottom:     memoryBottom,
+		EfiFreeMemoryTop:    memoryTop,
+		EfiFreeMemoryBottom: freeMemoryBottom,
+	}
+	// EfiEndOfHobList/EfiFreeMemoryBottom are patched in Finalize once the
+	// final list length is known; binary.Write never fails against a
+	// bytes.Buffer.
+	_ = binary.Write(&b.buf, binary.LittleEndian, phit)
+
+	return b
+}
+
+// write appends a header+body HOB, padding the body out to an 8-byte
+// aligned total length as EDK2 expects.
+func (b *Builder) write(hobType uint16, body []byte) error {
+	total := binary.Size(genericHeader{}) + len(body)
+	aligned := alignUp(total, hobAlign)
+	if aligned > math.MaxUint16 {
+		return ErrPayloadTooLarge
+	}
+
+	header := genericHeader{
+		HobType:   hobType,
+		HobLength: uint16(aligned),
+	}
+	if err := binary.Write(&b.buf, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	if _, err := b.buf.Write(body); err != nil {
+		return err
+	}
+	if pad := aligned - total; pad > 0 {
+		b.buf.Write(make([]byte, pad))
+	}
+	return nil
+}
+
+// alignUp rounds n up to the next multiple of align.
+func alignUp(n, align int) int {
+	return (n + align - 1) &^ (align - 1)
+}
+
+// AddCPU appends an EFI_HOB_CPU describing the physical and I/O address
+// space widths, in bits, that the payload's CPU supports.
+func (b *Builder) AddCPU(memBits, ioBits uint8) error {
+	var buf bytes.Buffer
+	cpu := cpuHob{
+		SizeOfMemorySpace: memBits,
+		SizeOfIoSpace:     ioBits,
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, cpu); err != nil {
+		return err
+	}
+	return b.write(typeCPU, buf.Bytes())
+}
+
+// AddMemoryAllocation appends an EFI_HOB_MEMORY_ALLOCATION recording an
+// anonymous allocation of length bytes at base, tagged with EFI memory type
+// memType.
+func (b *Builder) AddMemoryAllocation(base, length uint64, memType uint32) error {
+	var buf bytes.Buffer
+	alloc := memoryAllocationHob{
+		MemoryBaseAddr: base,
+		MemoryLength:   length,
+		MemoryType:     memType,
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, alloc); err != nil {
+		return err
+	}
+	return b.write(typeMemoryAllocation, buf.Bytes())
+}
+
+// AddResourceDescriptor appends an EFI_HOB_RESOURCE_DESCRIPTOR describing a
+// physical resource range [physicalStart, physicalStart+resourceLength) of
+// the given resourceType, with resourceAttribute flags (PI spec volume 3,
+// table 5-9/5-10) and owner identifying the HOB producer, or the zero GUID
+// if ownerless.
+func (b *Builder) AddResourceDescriptor(owner [16]byte, resourceType, resourceAttribute uint32, physicalStart, resourceLength uint64) error {
+	var buf bytes.Buffer
+	res := resourceDescriptorHob{
+		Owner:             owner,
+		ResourceType:      resourceType,
+		ResourceAttribute: resourceAttribute,
+		PhysicalStart:     physicalStart,
+		ResourceLength:    resourceLength,
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, res); err != nil {
+		return err
+	}
+	return b.write(typeResourceDescriptor, buf.Bytes())
+}
+
+// AddSerialPortInfo appends a GUID extension HOB carrying
+// UNIVERSAL_PAYLOAD_SERIAL_PORT_INFO, describing the serial console the
+// consuming payload should use: an MMIO or I/O-port register base,
+// register stride in bytes, and baud rate.
+func (b *Builder) AddSerialPortInfo(registerBase uint64, baudRate uint32, registerStride uint8, useMMIO bool) error {
+	info := serialPortInfo{
+		RegisterStride: registerStride,
+		BaudRate:       baudRate,
+		RegisterBase:   registerBase,
+	}
+	info.Header.Revision = 1
+	info.Header.Length = uint16(binary.Size(serialPortInfo{}))
+	if useMMIO {
+		info.UseMmio = 1
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, info); err != nil {
+		return err
+	}
+	return b.AddGuidExtension(gUniversalPayloadSerialPortInfoGuid, buf.Bytes())
+}
+
+// AddFvHob appends an EFI_HOB_FIRMWARE_VOLUME describing a firmware volume
+// of length bytes located at base.
+func (b *Builder) AddFvHob(base, length uint64) error {
+	var buf bytes.Buffer
+	fv := firmwareVolumeHob{
+		BaseAddr: base,
+		Length:   length,
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, fv); err != nil {
+		return err
+	}
+	return b.write(typeFV, buf.Bytes())
+}
+
+// AddGuidExtension appends an EFI_HOB_GUID_TYPE wrapping payload and tagged
+// with guid, for HOB types that carry a vendor- or spec-defined structure
+// rather than one of the built-in HOB types.
+func (b *Builder) AddGuidExtension(guid [16]byte, payload []byte) error {
+	body := make([]byte, 0, 16+len(payload))
+	body = append(body, guid[:]...)
+	body = append(body, payload...)
+	return b.write(typeGUIDExtension, body)
+}
+
+// EndOfHobList appends the EFI_HOB_GENERIC_HEADER-only HOB that terminates
+// a HOB list. Callers must add it before calling Finalize.
+func (b *Builder) EndOfHobList() error {
+	return b.write(typeEndOfHobList, nil)
+}
+
+// Finalize returns the accumulated HOB list bytes, after patching the PHIT
+// HOB's EfiFreeMemoryBottom and EfiEndOfHobList fields to reflect the final
+// size of the list, the same bookkeeping EDK2's HobLib performs on every
+// CreateHob call. It is an error to call Finalize more than once on the
+// same Builder.
+func (b *Builder) Finalize() ([]byte, error) {
+	if b.finalized {
+		return nil, ErrNotFinalized
+	}
+	b.finalized = true
+
+	out := b.buf.Bytes()
+
+	listEnd := b.freeMemoryBottom + uint64(len(out))
+	binary.LittleEndian.PutUint64(out[offsetFreeMemoryBottom:], listEnd)
+	binary.LittleEndian.PutUint64(out[offsetEndOfHobList:], listEnd)
+
+	return out, nil
+}
+
+// Byte offsets of the handoffInfoTable fields Finalize patches in place:
+// header, then Version and BootMode (uint32 each), then EfiMemoryTop,
+// EfiMemoryBottom and EfiFreeMemoryTop (uint64 each) precede
+// EfiFreeMemoryBottom, which is immediately followed by EfiEndOfHobList.
+const (
+	offsetFreeMemoryBottom = 8 + 4 + 4 + 8 + 8 + 8
+	offsetEndOfHobList     = offsetFreeMemoryBottom + 8
+)
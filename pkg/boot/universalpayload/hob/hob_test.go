@@ -0,0 +1,220 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hob
+
+import (
+	"bytes"
+	"testing"
+)
+
+// le64/le32/le16 append a little-endian encoded value to buf, to build the
+// golden byte layout a test expects to see.
+func le64(buf []byte, v uint64) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+func le32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func le16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v), byte(v>>8))
+}
+
+func header(buf []byte, hobType, length uint16) []byte {
+	buf = le16(buf, hobType)
+	buf = le16(buf, length)
+	return le32(buf, 0) // Reserved
+}
+
+func TestBuilderPHITOnly(t *testing.T) {
+	const memBottom, memTop, freeBottom = 0x1000, 0x100000, 0x2000
+
+	b := NewBuilder(memBottom, memTop, freeBottom)
+	if err := b.EndOfHobList(); err != nil {
+		t.Fatalf("EndOfHobList: %v", err)
+	}
+	got, err := b.Finalize()
+	if err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	const phitLen = 56 // header(8) + Version/BootMode(8) + 4*uint64(32) + EndOfHobList(8)
+	listEnd := uint64(freeBottom + phitLen + 8)
+
+	var want []byte
+	want = header(want, typeHandoff, phitLen)
+	want = le32(want, 0x00010000) // Version
+	want = le32(want, 0)          // BootMode
+	want = le64(want, memTop)
+	want = le64(want, memBottom)
+	want = le64(want, memTop)  // EfiFreeMemoryTop: unchanged by this builder
+	want = le64(want, listEnd) // EfiFreeMemoryBottom: patched by Finalize
+	want = le64(want, listEnd) // EfiEndOfHobList: patched by Finalize
+	want = header(want, typeEndOfHobList, 8)
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("HOB list mismatch:\ngot:  % x\nwant: % x", got, want)
+	}
+}
+
+func TestBuilderCPU(t *testing.T) {
+	b := NewBuilder(0, 0x100000, 0x2000)
+	if err := b.AddCPU(39, 16); err != nil {
+		t.Fatalf("AddCPU: %v", err)
+	}
+	if err := b.EndOfHobList(); err != nil {
+		t.Fatalf("EndOfHobList: %v", err)
+	}
+	got, err := b.Finalize()
+	if err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	const cpuLen = 16 // header(8) + SizeOfMemorySpace/SizeOfIoSpace(2) + Reserved[6], 8-byte aligned
+	var wantCPU []byte
+	wantCPU = header(wantCPU, typeCPU, cpuLen)
+	wantCPU = append(wantCPU, 39, 16)
+	wantCPU = append(wantCPU, make([]byte, 6)...) // Reserved[6]
+
+	if !bytes.Contains(got, wantCPU) {
+		t.Errorf("CPU HOB not found in list:\ngot:     % x\nwant in: % x", got, wantCPU)
+	}
+}
+
+func TestBuilderMemoryAllocation(t *testing.T) {
+	b := NewBuilder(0, 0x100000, 0x2000)
+	if err := b.AddMemoryAllocation(0x10000, 0x1000, 7); err != nil {
+		t.Fatalf("AddMemoryAllocation: %v", err)
+	}
+	got, err := b.Finalize()
+	if err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	const allocLen = 48 // header(8) + Name[16] + base(8) + length(8) + type(4) + Reserved[4]
+	var want []byte
+	want = header(want, typeMemoryAllocation, allocLen)
+	want = append(want, make([]byte, 16)...) // Name: zero GUID
+	want = le64(want, 0x10000)
+	want = le64(want, 0x1000)
+	want = le32(want, 7)
+	want = append(want, make([]byte, 4)...) // Reserved
+
+	if !bytes.Contains(got, want) {
+		t.Errorf("memory allocation HOB not found in list:\ngot:     % x\nwant in: % x", got, want)
+	}
+}
+
+func TestBuilderGuidExtension(t *testing.T) {
+	b := NewBuilder(0, 0x100000, 0x2000)
+	guid := [16]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+	payload := []byte{0xaa, 0xbb, 0xcc}
+	if err := b.AddGuidExtension(guid, payload); err != nil {
+		t.Fatalf("AddGuidExtension: %v", err)
+	}
+	got, err := b.Finalize()
+	if err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	const bodyLen = 8 + 16 + 3 // header + guid + payload
+	const aligned = 32         // rounded up to 8-byte alignment
+	var want []byte
+	want = header(want, typeGUIDExtension, aligned)
+	want = append(want, guid[:]...)
+	want = append(want, payload...)
+	want = append(want, make([]byte, aligned-bodyLen)...)
+
+	if !bytes.Contains(got, want) {
+		t.Errorf("GUID extension HOB not found in list:\ngot:     % x\nwant in: % x", got, want)
+	}
+}
+
+func TestBuilderResourceDescriptor(t *testing.T) {
+	b := NewBuilder(0, 0x100000, 0x2000)
+	owner := [16]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+	if err := b.AddResourceDescriptor(owner, 0, 0x7, 0x100000, 0x200000); err != nil {
+		t.Fatalf("AddResourceDescriptor: %v", err)
+	}
+	got, err := b.Finalize()
+	if err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	const resLen = 48 // header(8) + Owner[16] + ResourceType(4) + ResourceAttribute(4) + PhysicalStart(8) + ResourceLength(8)
+	var want []byte
+	want = header(want, typeResourceDescriptor, resLen)
+	want = append(want, owner[:]...)
+	want = le32(want, 0)
+	want = le32(want, 0x7)
+	want = le64(want, 0x100000)
+	want = le64(want, 0x200000)
+
+	if !bytes.Contains(got, want) {
+		t.Errorf("resource descriptor HOB not found in list:\ngot:     % x\nwant in: % x", got, want)
+	}
+}
+
+func TestBuilderFvHob(t *testing.T) {
+	b := NewBuilder(0, 0x100000, 0x2000)
+	if err := b.AddFvHob(0x800000, 0x40000); err != nil {
+		t.Fatalf("AddFvHob: %v", err)
+	}
+	got, err := b.Finalize()
+	if err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	const fvLen = 24 // header(8) + BaseAddr(8) + Length(8)
+	var want []byte
+	want = header(want, typeFV, fvLen)
+	want = le64(want, 0x800000)
+	want = le64(want, 0x40000)
+
+	if !bytes.Contains(got, want) {
+		t.Errorf("firmware volume HOB not found in list:\ngot:     % x\nwant in: % x", got, want)
+	}
+}
+
+func TestBuilderSerialPortInfo(t *testing.T) {
+	b := NewBuilder(0, 0x100000, 0x2000)
+	if err := b.AddSerialPortInfo(0xfe03_0000, 115200, 4, true); err != nil {
+		t.Fatalf("AddSerialPortInfo: %v", err)
+	}
+	got, err := b.Finalize()
+	if err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	const infoLen = 20               // Header(4) + UseMmio(1) + RegisterStride(1) + Reserved[2] + BaudRate(4) + RegisterBase(8)
+	const bodyLen = 8 + 16 + infoLen // header + guid + info
+	const aligned = 48               // rounded up to 8-byte alignment
+	var want []byte
+	want = header(want, typeGUIDExtension, aligned)
+	want = append(want, gUniversalPayloadSerialPortInfoGuid[:]...)
+	want = append(want, 1, 0)  // Header.Revision, Header.Reserved
+	want = le16(want, infoLen) // Header.Length
+	want = append(want, 1)     // UseMmio
+	want = append(want, 4)     // RegisterStride
+	want = append(want, 0, 0)  // Reserved[2]
+	want = le32(want, 115200)
+	want = le64(want, 0xfe03_0000)
+	want = append(want, make([]byte, aligned-bodyLen)...)
+
+	if !bytes.Contains(got, want) {
+		t.Errorf("serial port info HOB not found in list:\ngot:     % x\nwant in: % x", got, want)
+	}
+}
+
+func TestBuilderFinalizeTwiceFails(t *testing.T) {
+	b := NewBuilder(0, 0x100000, 0x2000)
+	if _, err := b.Finalize(); err != nil {
+		t.Fatalf("first Finalize: %v", err)
+	}
+	if _, err := b.Finalize(); err != ErrNotFinalized {
+		t.Errorf("second Finalize: got %v, want ErrNotFinalized", err)
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package universalpayload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// Flattened devicetree structure-block tokens, Devicetree Specification
+// section 5.4.
+const (
+	fdtBeginNode uint32 = 0x00000001
+	fdtEndNode   uint32 = 0x00000002
+	fdtProp      uint32 = 0x00000003
+	fdtEnd       uint32 = 0x00000009
+)
+
+// Flattened devicetree header constants, Devicetree Specification section
+// 5.2.
+const (
+	fdtMagic           uint32 = 0xd00dfeed
+	fdtVersion         uint32 = 17
+	fdtLastCompVersion uint32 = 16
+	fdtHeaderSize             = 40
+	fdtReserveMapSize         = 16 // one terminating {address: 0, size: 0} entry
+	// fdtStructBase is the fixed byte offset of the structure block
+	// within a blob produced by fdtBuilder.bytes: header, then the
+	// (always-empty) memory reservation block.
+	fdtStructBase = fdtHeaderSize + fdtReserveMapSize
+)
+
+// defaultPackAlign is the payload alignment PackFIT/Pack use when
+// PackOptions.Align is zero.
+const defaultPackAlign = 4
+
+// PackOptions configures Pack and PackFIT.
+type PackOptions struct {
+	// Load is the physical address the payload is expected to run at
+	// once loaded and, if compressed, decompressed.
+	Load uint64
+	// EntryStart is the payload's entry point address.
+	EntryStart uint64
+	// Arch is the ArchPropertyName value, e.g. "x86_64", "aarch64",
+	// "riscv" or "loongarch".
+	Arch string
+	// OS is the OSPropertyName value. Defaults to "tianocore" if empty.
+	OS string
+	// Compression selects how the payload region is stored: "" or
+	// "none", "gzip", or "lzma". Defaults to "none".
+	Compression string
+	// Align is the byte boundary the payload is padded to after the
+	// DTB. Defaults to defaultPackAlign if zero.
+	Align uint64
+}
+
+// Pack assembles payload and opts into a FIT image and returns its bytes.
+// See PackFIT for the image layout.
+func Pack(payload []byte, opts PackOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := PackFIT(&buf, payload, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PackFIT writes a FIT image to w: a devicetree blob carrying an
+// /images/tianocore node that describes payload (optionally compressed per
+// opts.Compression, with an integrity "hash-1" sub-node), immediately
+// followed by the payload itself, aligned to opts.Align. This is the
+// inverse of GetFdtInfo: a FIT PackFIT produces is loadable by GetFdtInfo
+// and the kexec UPL loader built on top of it.
+func PackFIT(w io.Writer, payload []byte, opts PackOptions) error {
+	osName := opts.OS
+	if osName == "" {
+		osName = "tianocore"
+	}
+	compression := opts.Compression
+	if compression == "" {
+		compression = compressionNone
+	}
+	align := opts.Align
+	if align == 0 {
+		align = defaultPackAlign
+	}
+
+	stored, err := compressPayload(payload, compression)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(stored)
+
+	b := newFdtBuilder()
+	b.beginNode("")
+	b.beginNode(FirstLevelNodeName)
+	b.beginNode(SecondLevelNodeName)
+	b.propU64(LoadAddrPropertyName, opts.Load)
+	b.propU64(EntryAddrPropertyName, opts.EntryStart)
+	dataOffsetProp := b.propU32(DataOffsetPropertyName, 0) // patched below
+	b.propU32(DataSizePropertyName, uint32(len(stored)))
+	b.propString(ArchPropertyName, opts.Arch)
+	b.propString(OSPropertyName, osName)
+	b.propString(CompressionPropertyName, compression)
+	b.beginNode("hash-1")
+	b.propString("algo", "sha256")
+	b.propBytes("value", sum[:])
+	b.endNode() // hash-1
+	b.endNode() // tianocore
+	b.endNode() // images
+	b.endNode() // root
+
+	dtb := b.bytes()
+
+	dataOffset := alignUp64(uint64(len(dtb)), align)
+	binary.BigEndian.PutUint32(dtb[dataOffsetProp:], uint32(dataOffset))
+
+	if _, err := w.Write(dtb); err != nil {
+		return err
+	}
+	if pad := dataOffset - uint64(len(dtb)); pad > 0 {
+		if _, err := w.Write(make([]byte, pad)); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(stored)
+	return err
+}
+
+// alignUp64 rounds n up to the next multiple of align.
+func alignUp64(n, align uint64) uint64 {
+	return (n + align - 1) &^ (align - 1)
+}
+
+// fdtBuilder assembles the minimal flattened devicetree blob PackFIT needs:
+// a handful of nested nodes each carrying a few properties. It is not a
+// general-purpose FDT writer; pkg/dt only reads devicetrees, so this is the
+// one place in the tree that produces one.
+type fdtBuilder struct {
+	structBuf bytes.Buffer
+	strings   bytes.Buffer
+	strOff    map[string]uint32
+}
+
+func newFdtBuilder() *fdtBuilder {
+	return &fdtBuilder{strOff: map[string]uint32{}}
+}
+
+func (b *fdtBuilder) beginNode(name string) {
+	_ = binary.Write(&b.structBuf, binary.BigEndian, fdtBeginNode)
+	b.structBuf.WriteString(name)
+	b.structBuf.WriteByte(0)
+	b.pad4()
+}
+
+func (b *fdtBuilder) endNode() {
+	_ = binary.Write(&b.structBuf, binary.BigEndian, fdtEndNode)
+}
+
+func (b *fdtBuilder) pad4() {
+	for b.structBuf.Len()%4 != 0 {
+		b.structBuf.WriteByte(0)
+	}
+}
+
+// nameOffset returns name's byte offset into the strings block, adding it
+// if this is the first time name has been used.
+func (b *fdtBuilder) nameOffset(name string) uint32 {
+	if off, ok := b.strOff[name]; ok {
+		return off
+	}
+	off := uint32(b.strings.Len())
+	b.strings.WriteString(name)
+	b.strings.WriteByte(0)
+	b.strOff[name] = off
+	return off
+}
+
+// prop appends an FDT_PROP token plus value to the structure block and
+// returns the byte offset, into the blob fdtBuilder.bytes eventually
+// returns, at which its value starts. Callers that need to patch a value
+// once the DTB's final size is known (PackFIT's data-offset) use this to
+// find it again.
+func (b *fdtBuilder) prop(name string, value []byte) int {
+	_ = binary.Write(&b.structBuf, binary.BigEndian, fdtProp)
+	_ = binary.Write(&b.structBuf, binary.BigEndian, uint32(len(value)))
+	_ = binary.Write(&b.structBuf, binary.BigEndian, b.nameOffset(name))
+	valueOffset := fdtStructBase + b.structBuf.Len()
+	b.structBuf.Write(value)
+	b.pad4()
+	return valueOffset
+}
+
+func (b *fdtBuilder) propU32(name string, v uint32) int {
+	var val [4]byte
+	binary.BigEndian.PutUint32(val[:], v)
+	return b.prop(name, val[:])
+}
+
+func (b *fdtBuilder) propU64(name string, v uint64) int {
+	var val [8]byte
+	binary.BigEndian.PutUint64(val[:], v)
+	return b.prop(name, val[:])
+}
+
+func (b *fdtBuilder) propString(name, v string) int {
+	return b.prop(name, append([]byte(v), 0))
+}
+
+func (b *fdtBuilder) propBytes(name string, v []byte) int {
+	return b.prop(name, v)
+}
+
+// bytes finalizes and returns the devicetree blob: header, an empty memory
+// reservation block, the structure block, then the strings block. Offsets
+// returned by prop/propU32/... are already relative to this returned
+// slice, per fdtStructBase.
+func (b *fdtBuilder) bytes() []byte {
+	_ = binary.Write(&b.structBuf, binary.BigEndian, fdtEnd)
+	structBytes := b.structBuf.Bytes()
+	stringsBytes := b.strings.Bytes()
+
+	offDtStruct := uint32(fdtStructBase)
+	offDtStrings := offDtStruct + uint32(len(structBytes))
+	totalSize := offDtStrings + uint32(len(stringsBytes))
+
+	var out bytes.Buffer
+	for _, v := range []uint32{
+		fdtMagic,
+		totalSize,
+		offDtStruct,
+		offDtStrings,
+		fdtHeaderSize, // off_mem_rsvmap
+		fdtVersion,
+		fdtLastCompVersion,
+		0, // boot_cpuid_phys
+		uint32(len(stringsBytes)),
+		uint32(len(structBytes)),
+	} {
+		_ = binary.Write(&out, binary.BigEndian, v)
+	}
+
+	// Memory reservation block: a single terminating {address, size} = {0, 0}
+	// entry, as this builder never reserves memory regions of its own.
+	_ = binary.Write(&out, binary.BigEndian, uint64(0))
+	_ = binary.Write(&out, binary.BigEndian, uint64(0))
+
+	out.Write(structBytes)
+	out.Write(stringsBytes)
+
+	return out.Bytes()
+}
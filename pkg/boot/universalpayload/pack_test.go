@@ -0,0 +1,79 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package universalpayload
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPackRoundTrip(t *testing.T) {
+	payload := []byte("hello universalpayload")
+	opts := PackOptions{
+		Load:       0x800000,
+		EntryStart: 0x800010,
+		Arch:       "x86_64",
+		OS:         "tianocore",
+	}
+
+	fit, err := Pack(payload, opts)
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	got, err := getFdtInfo("test.fit", bytes.NewReader(fit))
+	if err != nil {
+		t.Fatalf("getFdtInfo: %v", err)
+	}
+
+	if got.Load != opts.Load {
+		t.Errorf("Load = %#x, want %#x", got.Load, opts.Load)
+	}
+	if got.EntryStart != opts.EntryStart {
+		t.Errorf("EntryStart = %#x, want %#x", got.EntryStart, opts.EntryStart)
+	}
+	if got.DataSize != uint32(len(payload)) {
+		t.Errorf("DataSize = %d, want %d", got.DataSize, len(payload))
+	}
+	if got.Compression != compressionNone {
+		t.Errorf("Compression = %q, want %q", got.Compression, compressionNone)
+	}
+
+	end := int(got.DataOffset) + int(got.DataSize)
+	if end > len(fit) {
+		t.Fatalf("data region [%d:%d] out of bounds of %d-byte FIT", got.DataOffset, end, len(fit))
+	}
+	if !bytes.Equal(fit[got.DataOffset:end], payload) {
+		t.Errorf("payload region does not match the packed input")
+	}
+}
+
+func TestPackCompression(t *testing.T) {
+	for _, compression := range []string{"gzip", "lzma"} {
+		payload := bytes.Repeat([]byte("universalpayload"), 64)
+
+		fit, err := Pack(payload, PackOptions{Compression: compression})
+		if err != nil {
+			t.Fatalf("Pack(%s): %v", compression, err)
+		}
+
+		got, err := getFdtInfo("test.fit", bytes.NewReader(fit))
+		if err != nil {
+			t.Fatalf("getFdtInfo(%s): %v", compression, err)
+		}
+		if got.Compression != compression {
+			t.Errorf("Compression = %q, want %q", got.Compression, compression)
+		}
+
+		stored := fit[uint64(got.DataOffset) : uint64(got.DataOffset)+uint64(got.DataSize)]
+		out, err := decompressPayload(stored, compression)
+		if err != nil {
+			t.Fatalf("decompressPayload(%s): %v", compression, err)
+		}
+		if !bytes.Equal(out, payload) {
+			t.Errorf("round trip mismatch for %s", compression)
+		}
+	}
+}
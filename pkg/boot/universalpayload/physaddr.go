@@ -0,0 +1,76 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package universalpayload
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// sysfsCPUInfoPath is the last-resort fallback source for PhysAddrBits,
+// overridable in tests.
+var sysfsCPUInfoPath = "/proc/cpuinfo"
+
+// Errors returned by PhysAddrBits and its per-arch detectPhysAddrBits
+// implementations.
+var (
+	ErrPhysAddrBitsUnavailable = errors.New("unable to determine physical address size")
+	ErrCPUAddressConvert       = errors.New("failed to convert physical bits size")
+	ErrCPUAddressRead          = errors.New("failed to read 'address sizes'")
+	ErrCPUAddressNotFound      = errors.New("'address sizes' information not found")
+)
+
+// PhysAddrBits returns the number of bits of physical address the running
+// CPU supports, used to size the EFI_HOB_CPU HOB's SizeOfMemorySpace field.
+// Each GOARCH this package supports provides its own primary detection
+// strategy in detectPhysAddrBits (see physaddr_x86.go, physaddr_arm64.go,
+// physaddr_riscv64.go, physaddr_loong64.go); physAddrBitsFromCPUInfo is a
+// shared last-resort fallback for systems where that strategy fails.
+func PhysAddrBits() (uint8, error) {
+	if bits, err := detectPhysAddrBits(); err == nil {
+		return bits, nil
+	}
+	return physAddrBitsFromCPUInfo(sysfsCPUInfoPath)
+}
+
+// physAddrBitsFromCPUInfo reads the physical address size out of a
+// /proc/cpuinfo-formatted file. On x86 this is the "address sizes" line:
+//
+//	address sizes	: 39 bits physical, 48 bits virtual
+//
+// which is the only well-known cpuinfo field carrying it; other
+// architectures' cpuinfo output doesn't reliably include one, so this is
+// only ever used as a last resort once the per-arch strategy has failed.
+func physAddrBitsFromCPUInfo(path string) (uint8, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	re := regexp.MustCompile(`address sizes\s*:\s*(\d+)\s+bits physical,\s*(\d+)\s+bits virtual`)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := re.FindStringSubmatch(line); match != nil {
+			physicalBits, err := strconv.ParseUint(match[1], 10, 8)
+			if err != nil {
+				return 0, errors.Join(ErrCPUAddressConvert, err)
+			}
+			return uint8(physicalBits), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("%w: file: %s, err: %w", ErrCPUAddressRead, path, err)
+	}
+
+	return 0, ErrCPUAddressNotFound
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build arm64
+
+package universalpayload
+
+import "fmt"
+
+// readIDAA64MMFR0EL1 is implemented in mmfr0_arm64.s: it executes MRS
+// ID_AA64MMFR0_EL1, Xt and returns the register contents. Linux traps and
+// emulates reads of this register from EL0 (since kernel 4.11), so this
+// works in a userspace process without special privilege.
+func readIDAA64MMFR0EL1() uint64
+
+// mmfr0Func is the ID_AA64MMFR0_EL1 reader used by detectPhysAddrBits,
+// overridable in tests so they don't need to run on real arm64 hardware.
+var mmfr0Func = readIDAA64MMFR0EL1
+
+// paRangeBits maps the 4-bit PARange field of ID_AA64MMFR0_EL1 (Arm
+// Architecture Reference Manual for A-profile architecture, section
+// D19.2.66) to the physical address size it encodes.
+var paRangeBits = map[uint64]uint8{
+	0b0000: 32,
+	0b0001: 36,
+	0b0010: 40,
+	0b0011: 42,
+	0b0100: 44,
+	0b0101: 48,
+	0b0110: 52,
+	0b0111: 56,
+}
+
+// detectPhysAddrBits reads the PARange field (bits [3:0]) of
+// ID_AA64MMFR0_EL1 and maps it to a physical address size via paRangeBits.
+func detectPhysAddrBits() (uint8, error) {
+	paRange := mmfr0Func() & 0xf
+	bits, ok := paRangeBits[paRange]
+	if !ok {
+		return 0, fmt.Errorf("%w: unrecognized ID_AA64MMFR0_EL1.PARange %#x", ErrPhysAddrBitsUnavailable, paRange)
+	}
+	return bits, nil
+}
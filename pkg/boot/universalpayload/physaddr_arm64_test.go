@@ -0,0 +1,45 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build arm64
+
+package universalpayload
+
+import "testing"
+
+func TestDetectPhysAddrBitsARM64(t *testing.T) {
+	origMMFR0 := mmfr0Func
+	t.Cleanup(func() { mmfr0Func = origMMFR0 })
+
+	for _, tt := range []struct {
+		name    string
+		mmfr0   uint64
+		want    uint8
+		wantErr bool
+	}{
+		{name: "PARange 40 bits", mmfr0: 0b0010, want: 40},
+		{name: "PARange 44 bits", mmfr0: 0b0100, want: 44},
+		{name: "PARange 48 bits", mmfr0: 0b0101, want: 48},
+		{name: "PARange field masked out of wider register value", mmfr0: 0xabcd0000 | 0b0101, want: 48},
+		{name: "reserved PARange value", mmfr0: 0b1000, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mmfr0Func = func() uint64 { return tt.mmfr0 }
+
+			got, err := detectPhysAddrBits()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("detectPhysAddrBits() = %d, nil, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("detectPhysAddrBits(): %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("detectPhysAddrBits() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
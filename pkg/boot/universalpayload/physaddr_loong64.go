@@ -0,0 +1,27 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build loong64
+
+package universalpayload
+
+// cpucfg is implemented in cpucfg_loong64.s: it executes CPUCFG for the
+// given index and returns the resulting register contents.
+func cpucfg(index uint32) uint32
+
+// cpuidFunc is the CPUCFG reader used by detectPhysAddrBits, overridable
+// in tests so they don't need to run on real loong64 hardware.
+var cpuidFunc = cpucfg
+
+// cpucfgPALEN is the CPUCFG index whose bits [4:0] (PALEN) encode the
+// physical address width minus 1 (LoongArch Reference Manual, Volume 1,
+// section 2.2.10.1).
+const cpucfgPALEN = 1
+
+// detectPhysAddrBits reads CPUCFG word 1's PALEN field and returns the
+// physical address width it encodes.
+func detectPhysAddrBits() (uint8, error) {
+	palen := cpuidFunc(cpucfgPALEN) & 0x1f
+	return uint8(palen) + 1, nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build loong64
+
+package universalpayload
+
+import "testing"
+
+func TestDetectPhysAddrBitsLoong64(t *testing.T) {
+	origFunc := cpuidFunc
+	t.Cleanup(func() { cpuidFunc = origFunc })
+
+	for _, tt := range []struct {
+		name  string
+		palen uint32
+		want  uint8
+	}{
+		{name: "40-bit PALEN", palen: 39, want: 40},
+		{name: "48-bit PALEN", palen: 47, want: 48},
+		{name: "reserved bits above PALEN are ignored", palen: 0xffffffe0 | 39, want: 40},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cpuidFunc = func(index uint32) uint32 {
+				if index != cpucfgPALEN {
+					t.Fatalf("cpuidFunc called with index %d, want %d", index, cpucfgPALEN)
+				}
+				return tt.palen
+			}
+
+			got, err := detectPhysAddrBits()
+			if err != nil {
+				t.Fatalf("detectPhysAddrBits(): %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("detectPhysAddrBits() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build riscv64
+
+package universalpayload
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cpuDeviceTreeGlob matches the devicetree node for each hart under the
+// kernel's /proc/device-tree mirror, overridable in tests.
+var cpuDeviceTreeGlob = "/proc/device-tree/cpus/cpu@*"
+
+// mmuTypeToPAWidth maps the "mmu-type" property (RISC-V Privileged
+// Architecture spec) of a cpu node to the physical address width its paging
+// mode supports. A Sv39/Sv48/Sv57 PTE's PPN field is 44 bits wide
+// regardless of which of the three is active, giving a 56-bit (44+12 page
+// offset) physical address; Sv32's PPN is 22 bits, giving 34 bits.
+var mmuTypeToPAWidth = map[string]uint8{
+	"riscv,sv32": 34,
+	"riscv,sv39": 56,
+	"riscv,sv48": 56,
+	"riscv,sv57": 56,
+}
+
+// detectPhysAddrBits reads the "mmu-type" property off the first cpu node
+// it finds under cpuDeviceTreeGlob and maps it to a physical address width
+// via mmuTypeToPAWidth.
+func detectPhysAddrBits() (uint8, error) {
+	matches, err := filepath.Glob(cpuDeviceTreeGlob)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrPhysAddrBitsUnavailable, err)
+	}
+	for _, cpuNode := range matches {
+		data, err := os.ReadFile(filepath.Join(cpuNode, "mmu-type"))
+		if err != nil {
+			continue
+		}
+		mmuType := strings.TrimRight(string(data), "\x00")
+		if bits, ok := mmuTypeToPAWidth[mmuType]; ok {
+			return bits, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: no cpu node under %s had a recognized mmu-type", ErrPhysAddrBitsUnavailable, cpuDeviceTreeGlob)
+}
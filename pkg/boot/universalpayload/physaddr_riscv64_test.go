@@ -0,0 +1,43 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build riscv64
+
+package universalpayload
+
+import "testing"
+
+func TestDetectPhysAddrBitsRISCV64(t *testing.T) {
+	origGlob := cpuDeviceTreeGlob
+	t.Cleanup(func() { cpuDeviceTreeGlob = origGlob })
+
+	for _, tt := range []struct {
+		name    string
+		glob    string
+		want    uint8
+		wantErr bool
+	}{
+		{name: "sv39 mmu-type", glob: "testdata/dt_sv39/cpu@*", want: 56},
+		{name: "unrecognized mmu-type", glob: "testdata/dt_unknown/cpu@*", wantErr: true},
+		{name: "no matching cpu nodes", glob: "testdata/dt_missing/cpu@*", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cpuDeviceTreeGlob = tt.glob
+
+			got, err := detectPhysAddrBits()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("detectPhysAddrBits() = %d, nil, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("detectPhysAddrBits(): %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("detectPhysAddrBits() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package universalpayload
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPhysAddrBitsFromCPUInfo(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		path    string
+		want    uint8
+		wantErr error
+	}{
+		{
+			name: "address sizes present",
+			path: "testdata/cpuinfo_amd64.txt",
+			want: 46,
+		},
+		{
+			name:    "address sizes missing",
+			path:    "testdata/cpuinfo_no_address_sizes.txt",
+			wantErr: ErrCPUAddressNotFound,
+		},
+		{
+			name:    "file does not exist",
+			path:    "testdata/does-not-exist.txt",
+			wantErr: ErrCPUAddressRead,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := physAddrBitsFromCPUInfo(tt.path)
+			if tt.wantErr != nil {
+				if tt.name == "file does not exist" {
+					if err == nil {
+						t.Fatalf("physAddrBitsFromCPUInfo(%q) = nil error, want non-nil", tt.path)
+					}
+					return
+				}
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("physAddrBitsFromCPUInfo(%q) err = %v, want %v", tt.path, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("physAddrBitsFromCPUInfo(%q): %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("physAddrBitsFromCPUInfo(%q) = %d, want %d", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build amd64 || 386
+
+package universalpayload
+
+// cpuid is implemented in cpuid_amd64.s / cpuid_386.s: it executes the
+// CPUID instruction for leaf eaxArg, sub-leaf 0, and returns the resulting
+// register contents.
+func cpuid(eaxArg uint32) (eax, ebx, ecx, edx uint32)
+
+// cpuidFunc is the CPUID leaf 0x80000008 source used by detectPhysAddrBits,
+// overridable in tests so they don't need to run on real amd64/386
+// hardware.
+var cpuidFunc = cpuid
+
+// detectPhysAddrBits reads the physical address width advertised by CPUID
+// leaf 0x80000008, EAX[7:0] (AMD64 Architecture Programmer's Manual, Volume
+// 3, CPUID Fn8000_0008_EAX; Intel SDM Vol. 2A, same leaf). This is the
+// "Linear Address Size" CPUID leaf every amd64 and 386 CPU since roughly
+// 2003 implements.
+func detectPhysAddrBits() (uint8, error) {
+	eax, _, _, _ := cpuidFunc(0x80000008)
+	bits := uint8(eax & 0xff)
+	if bits == 0 {
+		return 0, ErrPhysAddrBitsUnavailable
+	}
+	return bits, nil
+}
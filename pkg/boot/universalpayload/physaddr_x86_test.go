@@ -0,0 +1,48 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build amd64 || 386
+
+package universalpayload
+
+import "testing"
+
+func TestDetectPhysAddrBitsX86(t *testing.T) {
+	origCPUID := cpuidFunc
+	t.Cleanup(func() { cpuidFunc = origCPUID })
+
+	for _, tt := range []struct {
+		name    string
+		eax     uint32
+		want    uint8
+		wantErr bool
+	}{
+		{name: "39-bit physical, 48-bit virtual", eax: 0x3027, want: 39},
+		{name: "52-bit physical, 57-bit virtual", eax: 0x3934, want: 52},
+		{name: "CPUID leaf unsupported", eax: 0, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cpuidFunc = func(eaxArg uint32) (uint32, uint32, uint32, uint32) {
+				if eaxArg != 0x80000008 {
+					t.Fatalf("cpuidFunc called with leaf %#x, want 0x80000008", eaxArg)
+				}
+				return tt.eax, 0, 0, 0
+			}
+
+			got, err := detectPhysAddrBits()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("detectPhysAddrBits() = %d, nil, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("detectPhysAddrBits(): %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("detectPhysAddrBits() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,31 +5,38 @@
 package universalpayload
 
 import (
-	"bufio"
 	"bytes"
+	"compress/gzip"
 	"debug/pe"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math"
-	"os"
-	"regexp"
-	"strconv"
 
 	"github.com/u-root/u-root/pkg/dt"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 // Properties to be fetched from device tree.
 const (
-	FirstLevelNodeName     = "images"
-	SecondLevelNodeName    = "tianocore"
-	LoadAddrPropertyName   = "load"
-	EntryAddrPropertyName  = "entry-start"
-	DataOffsetPropertyName = "data-offset"
-	DataSizePropertyName   = "data-size"
+	FirstLevelNodeName      = "images"
+	SecondLevelNodeName     = "tianocore"
+	LoadAddrPropertyName    = "load"
+	EntryAddrPropertyName   = "entry-start"
+	DataOffsetPropertyName  = "data-offset"
+	DataSizePropertyName    = "data-size"
+	ArchPropertyName        = "arch"
+	OSPropertyName          = "os"
+	CompressionPropertyName = "compression"
 )
 
+// compressionNone is the CompressionPropertyName value (and the default
+// used when the property is absent, for compatibility with FIT images
+// produced before Pack/PackFIT existed) meaning the payload region is
+// stored as-is.
+const compressionNone = "none"
+
 const (
 	tmpHobSize     = 0x1000
 	tmpStackSize   = 0x1000
@@ -39,45 +46,51 @@ const (
 )
 
 const (
-	// Relocation Types
-	IMAGE_REL_BASED_ABSOLUTE = 0
-	IMAGE_REL_BASED_HIGHLOW  = 3
-	IMAGE_REL_BASED_DIR64    = 10
+	// Relocation Types, see the PE/COFF base relocation table specification.
+	IMAGE_REL_BASED_ABSOLUTE       = 0
+	IMAGE_REL_BASED_HIGH           = 1
+	IMAGE_REL_BASED_LOW            = 2
+	IMAGE_REL_BASED_HIGHLOW        = 3
+	IMAGE_REL_BASED_HIGHADJ        = 4
+	IMAGE_REL_BASED_ARM_MOV32      = 5
+	IMAGE_REL_BASED_DIR64          = 10
+	IMAGE_REL_BASED_ARM64_BRANCH26 = 13
 )
 
-var sysfsCPUInfoPath = "/proc/cpuinfo"
-
 type FdtLoad struct {
 	Load       uint64
 	EntryStart uint64
 	DataOffset uint32
 	DataSize   uint32
+	// Compression is the CompressionPropertyName value ("none", "gzip"
+	// or "lzma") describing how the payload region is stored. Absent in
+	// images predating Pack/PackFIT, in which case it reads as "none".
+	Compression string
 }
 
 // Errors returned by utilities
 var (
-	ErrFailToReadFdtFile       = errors.New("failed to read fdt file")
-	ErrNodeImagesNotFound      = fmt.Errorf("failed to find '%s' node", FirstLevelNodeName)
-	ErrNodeTianocoreNotFound   = fmt.Errorf("failed to find '%s' node", SecondLevelNodeName)
-	ErrNodeLoadNotFound        = fmt.Errorf("failed to find get '%s' property", LoadAddrPropertyName)
-	ErrNodeEntryStartNotFound  = fmt.Errorf("failed to find get '%s' property", EntryAddrPropertyName)
-	ErrNodeDataOffsetNotFound  = fmt.Errorf("failed to find get '%s' property", DataOffsetPropertyName)
-	ErrNodeDataSizeNotFound    = fmt.Errorf("failed to find get '%s' property", DataSizePropertyName)
-	ErrFailToConvertLoad       = fmt.Errorf("failed to convert property '%s' to u64", LoadAddrPropertyName)
-	ErrFailToConvertEntryStart = fmt.Errorf("failed to convert property '%s' to u64", EntryAddrPropertyName)
-	ErrFailToConvertDataOffset = fmt.Errorf("failed to convert property '%s' to u32", DataOffsetPropertyName)
-	ErrFailToConvertDataSize   = fmt.Errorf("failed to convert property '%s' to u32", DataSizePropertyName)
-	ErrPeFailToGetPageRVA      = fmt.Errorf("failed to read pagerva during pe file relocation")
-	ErrPeFailToGetBlockSize    = fmt.Errorf("failed to read block size during pe file relocation")
-	ErrPeFailToGetEntry        = fmt.Errorf("failed to get entry during pe file relocation")
-	ErrPeFailToCreatePeFile    = fmt.Errorf("failed to create pe file")
-	ErrPeFailToGetRelocData    = fmt.Errorf("failed to get .reloc section data")
-	ErrPeUnsupportedPeHeader   = fmt.Errorf("unsupported pe header format")
-	ErrPeRelocOutOfBound       = fmt.Errorf("relocation address out of bounds during pe file relocation")
-	ErrCPUAddressNotFound      = errors.New("'address sizes' information not found")
-	ErrCPUAddressRead          = errors.New("failed to read 'address sizes'")
-	ErrCPUAddressConvert       = errors.New("failed to convert physical bits size")
-	ErrAlignPadRange           = errors.New("failed to align pad size, out of range")
+	ErrFailToReadFdtFile        = errors.New("failed to read fdt file")
+	ErrNodeImagesNotFound       = fmt.Errorf("failed to find '%s' node", FirstLevelNodeName)
+	ErrNodeTianocoreNotFound    = fmt.Errorf("failed to find '%s' node", SecondLevelNodeName)
+	ErrNodeLoadNotFound         = fmt.Errorf("failed to find get '%s' property", LoadAddrPropertyName)
+	ErrNodeEntryStartNotFound   = fmt.Errorf("failed to find get '%s' property", EntryAddrPropertyName)
+	ErrNodeDataOffsetNotFound   = fmt.Errorf("failed to find get '%s' property", DataOffsetPropertyName)
+	ErrNodeDataSizeNotFound     = fmt.Errorf("failed to find get '%s' property", DataSizePropertyName)
+	ErrFailToConvertLoad        = fmt.Errorf("failed to convert property '%s' to u64", LoadAddrPropertyName)
+	ErrFailToConvertEntryStart  = fmt.Errorf("failed to convert property '%s' to u64", EntryAddrPropertyName)
+	ErrFailToConvertDataOffset  = fmt.Errorf("failed to convert property '%s' to u32", DataOffsetPropertyName)
+	ErrFailToConvertDataSize    = fmt.Errorf("failed to convert property '%s' to u32", DataSizePropertyName)
+	ErrFailToConvertCompression = fmt.Errorf("failed to convert property '%s' to string", CompressionPropertyName)
+	ErrUnsupportedCompression   = errors.New("unsupported compression type")
+	ErrPeFailToGetPageRVA       = fmt.Errorf("failed to read pagerva during pe file relocation")
+	ErrPeFailToGetBlockSize     = fmt.Errorf("failed to read block size during pe file relocation")
+	ErrPeFailToGetEntry         = fmt.Errorf("failed to get entry during pe file relocation")
+	ErrPeFailToCreatePeFile     = fmt.Errorf("failed to create pe file")
+	ErrPeFailToGetRelocData     = fmt.Errorf("failed to get .reloc section data")
+	ErrPeUnsupportedPeHeader    = fmt.Errorf("unsupported pe header format")
+	ErrPeRelocOutOfBound        = fmt.Errorf("relocation address out of bounds during pe file relocation")
+	ErrPeFailToGetAdjustEntry   = fmt.Errorf("failed to get HIGHADJ adjust entry during pe file relocation")
 )
 
 // GetFdtInfo Device Tree Blob resides at the start of FIT binary. In order to
@@ -164,121 +177,89 @@ func getFdtInfo(name string, dtb io.ReaderAt) (*FdtLoad, error) {
 		return nil, errors.Join(ErrFailToConvertDataSize, err)
 	}
 
-	return &FdtLoad{
-		Load:       loadAddr,
-		EntryStart: entryAddr,
-		DataOffset: dataOffset,
-		DataSize:   dataSize,
-	}, nil
-}
-
-// Get Physical Address size from sysfs node /proc/cpuinfo.
-// Both Physical and Virtual Address size will be prompted as format:
-// "address sizes	: 39 bits physical, 48 bits virtual"
-// Use regular expression to fetch the integer of Physical Address
-// size before "bits physical" keyword
-func getPhysicalAddressSizes() (uint8, error) {
-	file, err := os.Open(sysfsCPUInfoPath)
-	if err != nil {
-		return 0, fmt.Errorf("failed to open %s: %w", sysfsCPUInfoPath, err)
-	}
-	defer file.Close()
-
-	// Regular expression to match the address size line
-	re := regexp.MustCompile(`address sizes\s*:\s*(\d+)\s+bits physical,\s*(\d+)\s+bits virtual`)
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if match := re.FindStringSubmatch(line); match != nil {
-			// Convert the physical bits size to integer
-			physicalBits, err := strconv.ParseUint(match[1], 10, 8)
-			if err != nil {
-				return 0, errors.Join(ErrCPUAddressConvert, err)
-			}
-			return uint8(physicalBits), nil
+	// compression is optional: images predating Pack/PackFIT never set
+	// it, and an absent property means the payload is stored as-is.
+	compression := compressionNone
+	if compressionProp, succeed := secondLevelNode.LookProperty(CompressionPropertyName); succeed {
+		compression, err = compressionProp.AsString()
+		if err != nil {
+			return nil, errors.Join(ErrFailToConvertCompression, err)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return 0, fmt.Errorf("%w: file: %s, err: %w", ErrCPUAddressRead, sysfsCPUInfoPath, err)
-	}
-
-	return 0, ErrCPUAddressNotFound
+	return &FdtLoad{
+		Load:        loadAddr,
+		EntryStart:  entryAddr,
+		DataOffset:  dataOffset,
+		DataSize:    dataSize,
+		Compression: compression,
+	}, nil
 }
 
-// alignHOBLength writes pad bytes at the end of a HOB buf
-// It's because we calculate HOB length with golang, while write bytes to the buf with actual length
-func alignHOBLength(expectLen uint64, bufLen int, buf *bytes.Buffer) error {
-	if expectLen < uint64(bufLen) {
-		return ErrAlignPadRange
-	}
-
-	if expectLen > math.MaxInt {
-		return ErrAlignPadRange
-	}
-	if padLen := int(expectLen) - bufLen; padLen > 0 {
-		pad := make([]byte, padLen)
-		if err := binary.Write(buf, binary.LittleEndian, pad); err != nil {
-			return err
-		}
-	}
-	return nil
+// trampolineBuilder constructs the architecture-specific trampoline code
+// blob that kexec jumps to before handing control to the FIT image's
+// entry point. Each supported GOARCH provides its own implementation in
+// its arch_*.go file, and newTrampolineBuilder picks the one matching the
+// running runtime.GOARCH.
+type trampolineBuilder interface {
+	build(buf []uint8, hobAddr uint64, entry uint64) []uint8
 }
 
-// Constrcut trampoline code before jump to entry point of FIT image.
-// Due to lack of support to set value of General Purpose Registers in kexec,
-// bootloader parameter needs to be prepared in trampoline code.
-// Also stack is prepared in trampoline code snippet to ensure no data leak.
-//
-// Trampoline code snippet is prepared as following:
-//
-//	trampoline[0 - 6]   : mov rax, qword ptr [rip+0x19]
-//	trampoline[7 - 9]   : mov rsp, rax
-//	trampoline[10 - 16] : mov rax, qword ptr [rip+0x17]
-//	trampoline[17 - 19] : mov rcx, rax
-//	trampoline[20 - 26] : mov rax, qword ptr [rip+0x15]
-//	trampoline[27 - 28] : jmp rax
-//	trampoline[29 - 31] : padding for alignment
-//	trampoline[32 - 39] : Top of stack address
-//	trampoline[40 - 47] : Base address of bootloader parameter
-//	trampoline[48 - 55] : Entry point of FIT image
+// constructTrampoline constructs trampoline code before jump to entry
+// point of FIT image. Due to lack of support to set value of General
+// Purpose/System Registers in kexec, bootloader parameter needs to be
+// prepared in trampoline code. Also stack is prepared in trampoline code
+// snippet to ensure no data leak. The actual instruction sequence is
+// architecture-specific; see the trampolineBuilder implementation for the
+// running GOARCH.
 func constructTrampoline(buf []uint8, hobAddr uint64, entry uint64) []uint8 {
-	loadStackAddress := []uint8{0x48, 0x8b, 0x05, 0x19, 0x00, 0x00, 0x00}
-	setStackAddress := []uint8{0x48, 0x89, 0xc4}
-	loadBootparameter := []uint8{0x48, 0x8b, 0x05, 0x17, 0x00, 0x00, 0x00}
-	setBootparameter := []uint8{0x48, 0x89, 0xc1}
-	loadKernelAddress := []uint8{0x48, 0x8b, 0x05, 0x15, 0x00, 0x00, 0x00}
-	jumpToKernelAddress := []uint8{0xff, 0xe0}
-	padForAlignment := []uint8{0x00, 0x00, 0x00}
-
-	buf = append(buf, loadStackAddress...)
-	buf = append(buf, setStackAddress...)
-	buf = append(buf, loadBootparameter...)
-	buf = append(buf, setBootparameter...)
-	buf = append(buf, loadKernelAddress...)
-	buf = append(buf, jumpToKernelAddress...)
-	buf = append(buf, padForAlignment...)
-
-	stackTop := hobAddr + tmpStackTop
-	appendUint64 := func(slice []uint8, value uint64) []uint8 {
-		tmpBytes := make([]uint8, 8)
-		binary.LittleEndian.PutUint64(tmpBytes, value)
-		return append(slice, tmpBytes...)
+	return newTrampolineBuilder().build(buf, hobAddr, entry)
+}
+
+// optionalHeaderBase returns the ImageBase field of a PE file's optional
+// header, regardless of whether the image is PE32 (OptionalHeader32, as
+// produced by some EDK2 cross-arch UPL builds) or PE32+ (OptionalHeader64).
+func optionalHeaderBase(peFile *pe.File) (uint64, error) {
+	switch oh := peFile.OptionalHeader.(type) {
+	case *pe.OptionalHeader64:
+		return oh.ImageBase, nil
+	case *pe.OptionalHeader32:
+		return uint64(oh.ImageBase), nil
+	default:
+		return 0, ErrPeUnsupportedPeHeader
 	}
+}
 
-	buf = appendUint64(buf, stackTop)
-	buf = appendUint64(buf, hobAddr)
-	buf = appendUint64(buf, entry)
+// armThumb2ExtractImm16 recovers the 16-bit immediate encoded across the two
+// halfwords of a Thumb-2 MOVW/MOVT instruction (ARM ARM A7.7.75/A7.7.76):
+// imm16 = imm4:i:imm3:imm8.
+func armThumb2ExtractImm16(hw1, hw2 uint16) uint16 {
+	i := (hw1 >> 10) & 0x1
+	imm4 := hw1 & 0xf
+	imm3 := (hw2 >> 12) & 0x7
+	imm8 := hw2 & 0xff
+	return (imm4 << 12) | (i << 11) | (imm3 << 8) | imm8
+}
 
-	return buf
+// armThumb2InsertImm16 writes imm16 back into the MOVW/MOVT halfword pair,
+// leaving the opcode and destination register bits untouched.
+func armThumb2InsertImm16(hw1, hw2, imm16 uint16) (uint16, uint16) {
+	imm4 := (imm16 >> 12) & 0xf
+	i := (imm16 >> 11) & 0x1
+	imm3 := (imm16 >> 8) & 0x7
+	imm8 := imm16 & 0xff
+
+	hw1 = (hw1 &^ uint16(0x040f)) | (i << 10) | imm4
+	hw2 = (hw2 &^ uint16(0x70ff)) | (imm3 << 12) | imm8
+	return hw1, hw2
 }
 
 // Walk through .reloc section, update expected address to actual address
-// which is calculated with recloation offset. Currently, only type of
-// IMAGE_REL_BASED_DIR64(10) found in .reloc setcion, update this type
-// of address only.
-func relocatePE(relocData []byte, delta uint64, data []byte) error {
+// which is calculated with relocation offset. machine selects the
+// machine-specific relocation types (IMAGE_REL_BASED_ARM_MOV32,
+// IMAGE_REL_BASED_ARM64_BRANCH26) that share a numeric type value with
+// other architectures.
+func relocatePE(relocData []byte, delta uint64, data []byte, machine uint16) error {
 	r := bytes.NewReader(relocData)
 
 	for {
@@ -299,64 +280,244 @@ func relocatePE(relocData []byte, delta uint64, data []byte) error {
 		}
 
 		// Block size includes the header, so the number of entries is (blockSize - 8) / 2
-		entryCount := (blockSize - 8) / 2
-		for i := 0; i < int(entryCount); i++ {
+		entryCount := int((blockSize - 8) / 2)
+		for i := 0; i < entryCount; i++ {
 			var entry uint16
-			err := binary.Read(r, binary.LittleEndian, &entry)
-			if err != nil {
+			if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
 				return ErrPeFailToGetEntry
 			}
 
 			// Type is in the high 4 bits, offset is in the low 12 bits
 			entryType := entry >> 12
 			entryOffset := entry & 0xfff
+			relocAddr := pageRVA + uint32(entryOffset)
+
+			switch entryType {
+			case IMAGE_REL_BASED_ABSOLUTE:
+				// Padding entry, no relocation to perform.
+
+			case IMAGE_REL_BASED_HIGH:
+				if relocAddr+2 > uint32(len(data)) {
+					return ErrPeRelocOutOfBound
+				}
+				original := binary.LittleEndian.Uint16(data[relocAddr:])
+				binary.LittleEndian.PutUint16(data[relocAddr:], original+uint16(delta>>16))
+
+			case IMAGE_REL_BASED_LOW:
+				if relocAddr+2 > uint32(len(data)) {
+					return ErrPeRelocOutOfBound
+				}
+				original := binary.LittleEndian.Uint16(data[relocAddr:])
+				binary.LittleEndian.PutUint16(data[relocAddr:], original+uint16(delta))
+
+			case IMAGE_REL_BASED_HIGHLOW:
+				if relocAddr+4 > uint32(len(data)) {
+					return ErrPeRelocOutOfBound
+				}
+				original := binary.LittleEndian.Uint32(data[relocAddr:])
+				binary.LittleEndian.PutUint32(data[relocAddr:], original+uint32(delta))
+
+			case IMAGE_REL_BASED_HIGHADJ:
+				// HIGHADJ consumes the following 16-bit entry as the low
+				// half of the value to relocate; it is not itself a
+				// type/offset tagged entry.
+				if i+1 >= entryCount {
+					return ErrPeRelocOutOfBound
+				}
+				var adjust uint16
+				if err := binary.Read(r, binary.LittleEndian, &adjust); err != nil {
+					return ErrPeFailToGetAdjustEntry
+				}
+				i++
+
+				if relocAddr+2 > uint32(len(data)) {
+					return ErrPeRelocOutOfBound
+				}
+				origHigh := binary.LittleEndian.Uint16(data[relocAddr:])
+				combined := (uint32(origHigh) << 16) | uint32(adjust)
+				combined += uint32(delta)
+				// Round the low 16 bits back into the high half, as the
+				// low half is tracked separately by the consuming code.
+				newHigh := uint16((combined + 0x8000) >> 16)
+				binary.LittleEndian.PutUint16(data[relocAddr:], newHigh)
+
+			case IMAGE_REL_BASED_ARM_MOV32:
+				if machine != pe.IMAGE_FILE_MACHINE_ARMNT && machine != pe.IMAGE_FILE_MACHINE_ARM {
+					continue
+				}
+				if relocAddr+8 > uint32(len(data)) {
+					return ErrPeRelocOutOfBound
+				}
+				movwHw1 := binary.LittleEndian.Uint16(data[relocAddr:])
+				movwHw2 := binary.LittleEndian.Uint16(data[relocAddr+2:])
+				movtHw1 := binary.LittleEndian.Uint16(data[relocAddr+4:])
+				movtHw2 := binary.LittleEndian.Uint16(data[relocAddr+6:])
+
+				lo := armThumb2ExtractImm16(movwHw1, movwHw2)
+				hi := armThumb2ExtractImm16(movtHw1, movtHw2)
+				value := (uint32(hi)<<16 | uint32(lo)) + uint32(delta)
+
+				movwHw1, movwHw2 = armThumb2InsertImm16(movwHw1, movwHw2, uint16(value))
+				movtHw1, movtHw2 = armThumb2InsertImm16(movtHw1, movtHw2, uint16(value>>16))
 
-			// Only type IMAGE_REL_BASED_DIR64(10) found
-			if entryType == IMAGE_REL_BASED_DIR64 {
-				// Perform relocation
-				relocAddr := pageRVA + uint32(entryOffset)
-				if relocAddr >= uint32(len(data)) {
+				binary.LittleEndian.PutUint16(data[relocAddr:], movwHw1)
+				binary.LittleEndian.PutUint16(data[relocAddr+2:], movwHw2)
+				binary.LittleEndian.PutUint16(data[relocAddr+4:], movtHw1)
+				binary.LittleEndian.PutUint16(data[relocAddr+6:], movtHw2)
+
+			case IMAGE_REL_BASED_DIR64:
+				if relocAddr+8 > uint32(len(data)) {
+					return ErrPeRelocOutOfBound
+				}
+				original := binary.LittleEndian.Uint64(data[relocAddr:])
+				binary.LittleEndian.PutUint64(data[relocAddr:], original+delta)
+
+			case IMAGE_REL_BASED_ARM64_BRANCH26:
+				if machine != pe.IMAGE_FILE_MACHINE_ARM64 {
+					continue
+				}
+				if relocAddr+4 > uint32(len(data)) {
 					return ErrPeRelocOutOfBound
 				}
-				originalValue := binary.LittleEndian.Uint64(data[relocAddr:])
-				relocatedValue := originalValue + delta
-				binary.LittleEndian.PutUint64(data[relocAddr:], relocatedValue)
+				instr := binary.LittleEndian.Uint32(data[relocAddr:])
+				imm26 := instr & 0x03ffffff
+				if imm26&0x02000000 != 0 {
+					imm26 |= 0xfc000000
+				}
+				offset := int32(imm26)*4 + int32(delta)
+				newImm26 := uint32(offset/4) & 0x03ffffff
+				instr = (instr &^ uint32(0x03ffffff)) | newImm26
+				binary.LittleEndian.PutUint32(data[relocAddr:], instr)
+
+			default:
+				// Unsupported/unused relocation type (e.g. MIPS, RISC-V
+				// HIGH20/LOW12): nothing in this package produces or
+				// consumes those today, so leave the bytes untouched.
 			}
 		}
 	}
 	return nil
 }
 
-func relocateFdtdata(dst uint64, fdtLoad *FdtLoad, data []byte) error {
+// compressPayload returns data compressed according to compression
+// ("none"/"" for no-op, "gzip", or "lzma"), the inverse of
+// decompressPayload.
+func compressPayload(data []byte, compression string) ([]byte, error) {
+	switch compression {
+	case "", compressionNone:
+		return data, nil
+
+	case "gzip":
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case "lzma":
+		var buf bytes.Buffer
+		w, err := lzma.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCompression, compression)
+	}
+}
+
+// decompressPayload returns data decompressed according to compression
+// ("none"/"" for no-op, "gzip", or "lzma"), the same values PackFIT writes
+// to CompressionPropertyName.
+func decompressPayload(data []byte, compression string) ([]byte, error) {
+	switch compression {
+	case "", compressionNone:
+		return data, nil
+
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Join(ErrUnsupportedCompression, err)
+		}
+		defer r.Close()
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Join(ErrUnsupportedCompression, err)
+		}
+		return out, nil
+
+	case "lzma":
+		r, err := lzma.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Join(ErrUnsupportedCompression, err)
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Join(ErrUnsupportedCompression, err)
+		}
+		return out, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCompression, compression)
+	}
+}
+
+// relocateFdtdata decompresses (if needed) and relocates the PE payload
+// referenced by fdtLoad so it is ready to run at dst, returning the
+// resulting bytes. When verify is non-nil, the payload's Authenticode
+// signature is checked first, against the decompressed PE image, and
+// loading aborts if it is missing, malformed, or untrusted.
+func relocateFdtdata(dst uint64, fdtLoad *FdtLoad, data []byte, verify *VerifyOptions) ([]byte, error) {
 	// Get the region of universalpayload binary from FIT image
 	start := fdtLoad.DataOffset
 	end := fdtLoad.DataOffset + fdtLoad.DataSize
 
-	reader := bytes.NewReader(data[start:end])
+	payload, err := decompressPayload(data[start:end], fdtLoad.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	if verify != nil {
+		if err := verifyAuthenticodePE(payload, verify.Roots); err != nil {
+			return nil, fmt.Errorf("failed to verify FIT payload: %w", err)
+		}
+	}
+
+	reader := bytes.NewReader(payload)
 
 	peFile, err := pe.NewFile(reader)
 	if err != nil {
-		return ErrPeFailToCreatePeFile
+		return nil, ErrPeFailToCreatePeFile
 	}
 	defer peFile.Close()
 
-	optionalHeader, success := peFile.OptionalHeader.(*pe.OptionalHeader64)
-	if !success {
-		return ErrPeUnsupportedPeHeader
+	preBase, err := optionalHeaderBase(peFile)
+	if err != nil {
+		return nil, err
 	}
 
-	preBase := optionalHeader.ImageBase
 	delta := dst + uint64(fdtLoad.DataOffset) - preBase
 
 	for _, section := range peFile.Sections {
 		if section.Name == ".reloc" {
 			relocData, err := section.Data()
 			if err != nil {
-				return ErrPeFailToGetRelocData
+				return nil, ErrPeFailToGetRelocData
 			}
 
-			if err := relocatePE(relocData, delta, data[start:end]); err != nil {
-				return err
+			if err := relocatePE(relocData, delta, payload, peFile.FileHeader.Machine); err != nil {
+				return nil, err
 			}
 		}
 	}
@@ -364,5 +525,5 @@ func relocateFdtdata(dst uint64, fdtLoad *FdtLoad, data []byte) error {
 	fdtLoad.EntryStart = dst + (fdtLoad.EntryStart - fdtLoad.Load)
 	fdtLoad.Load = dst
 
-	return nil
+	return payload, nil
 }
@@ -0,0 +1,250 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package universalpayload
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// relocEntry packs a relocation type/offset pair into the 16-bit entry
+// format IMAGE_BASE_RELOCATION blocks use.
+func relocEntry(typ, offset uint16) uint16 {
+	return typ<<12 | (offset & 0xfff)
+}
+
+// relocBlock builds a single IMAGE_BASE_RELOCATION block: an 8-byte header
+// (PageRVA, BlockSize) followed by entries. Raw, non-type-tagged uint16
+// values (e.g. a HIGHADJ adjust word) can be passed alongside relocEntry
+// results; the block doesn't distinguish them.
+func relocBlock(pageRVA uint32, entries ...uint16) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, pageRVA)
+	binary.Write(&buf, binary.LittleEndian, uint32(8+2*len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, e)
+	}
+	return buf.Bytes()
+}
+
+// paddedData returns a size-byte buffer, filled with fill, with patch
+// written starting at offset.
+func paddedData(size int, fill byte, offset int, patch []byte) []byte {
+	data := bytes.Repeat([]byte{fill}, size)
+	copy(data[offset:], patch)
+	return data
+}
+
+func TestRelocatePE(t *testing.T) {
+	const bufSize = 0x20
+	const relocOffset = 0x10
+
+	for _, tt := range []struct {
+		name    string
+		relocs  []byte
+		delta   uint64
+		data    []byte
+		want    []byte
+		machine uint16
+		wantErr error
+	}{
+		{
+			name:    "IMAGE_REL_BASED_HIGH",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_HIGH, 0)),
+			delta:   0x00010000,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x1234)),
+			want:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x1235)),
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+		},
+		{
+			name:    "IMAGE_REL_BASED_LOW",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_LOW, 0)),
+			delta:   0x00000005,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x00aa)),
+			want:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x00af)),
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+		},
+		{
+			name:    "IMAGE_REL_BASED_HIGHLOW",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_HIGHLOW, 0)),
+			delta:   0x00000010,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint32(0x11112222)),
+			want:    paddedData(bufSize, 0xaa, relocOffset, leUint32(0x11112232)),
+			machine: pe.IMAGE_FILE_MACHINE_I386,
+		},
+		{
+			name: "IMAGE_REL_BASED_HIGHADJ",
+			relocs: relocBlock(relocOffset,
+				relocEntry(IMAGE_REL_BASED_HIGHADJ, 0),
+				0x8000, // adjust entry (low half), consumed raw, not type-tagged
+			),
+			delta:   0x00010000,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x0010)),
+			want:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x0012)),
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+		},
+		{
+			name:    "IMAGE_REL_BASED_DIR64",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_DIR64, 0)),
+			delta:   0x10,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint64(0x1122334455667788)),
+			want:    paddedData(bufSize, 0xaa, relocOffset, leUint64(0x1122334455667798)),
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+		},
+		{
+			name:    "IMAGE_REL_BASED_ABSOLUTE is a no-op padding entry",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_ABSOLUTE, 0)),
+			delta:   0xdeadbeef,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x1234)),
+			want:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x1234)),
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+		},
+		{
+			name:    "unsupported relocation type is left untouched",
+			relocs:  relocBlock(relocOffset, relocEntry(9, 0)), // IMAGE_REL_BASED_MIPS_JMPADDR
+			delta:   0xdeadbeef,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x1234)),
+			want:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x1234)),
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+		},
+		{
+			name:   "IMAGE_REL_BASED_ARM_MOV32 on matching machine",
+			relocs: relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_ARM_MOV32, 0)),
+			delta:  0x00010000,
+			data: paddedData(bufSize, 0xaa, relocOffset,
+				concatBytes(leUint16(0xf241), leUint16(0x2c34), leUint16(0xf2c0), leUint16(0x0c01))),
+			want: paddedData(bufSize, 0xaa, relocOffset,
+				concatBytes(leUint16(0xf241), leUint16(0x2c34), leUint16(0xf2c0), leUint16(0x0c02))),
+			machine: pe.IMAGE_FILE_MACHINE_ARMNT,
+		},
+		{
+			name:   "IMAGE_REL_BASED_ARM_MOV32 skipped on non-ARM machine",
+			relocs: relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_ARM_MOV32, 0)),
+			delta:  0x00010000,
+			data: paddedData(bufSize, 0xaa, relocOffset,
+				concatBytes(leUint16(0xf241), leUint16(0x2c34), leUint16(0xf2c0), leUint16(0x0c01))),
+			want: paddedData(bufSize, 0xaa, relocOffset,
+				concatBytes(leUint16(0xf241), leUint16(0x2c34), leUint16(0xf2c0), leUint16(0x0c01))),
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+		},
+		{
+			name:    "IMAGE_REL_BASED_ARM64_BRANCH26 on matching machine",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_ARM64_BRANCH26, 0)),
+			delta:   16,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint32(0x14000001)),
+			want:    paddedData(bufSize, 0xaa, relocOffset, leUint32(0x14000005)),
+			machine: pe.IMAGE_FILE_MACHINE_ARM64,
+		},
+		{
+			name:    "IMAGE_REL_BASED_ARM64_BRANCH26 skipped on non-ARM64 machine",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_ARM64_BRANCH26, 0)),
+			delta:   16,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint32(0x14000001)),
+			want:    paddedData(bufSize, 0xaa, relocOffset, leUint32(0x14000001)),
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+		},
+		{
+			name:    "HIGH relocation address out of bounds",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_HIGH, 0)),
+			delta:   0x10000,
+			data:    paddedData(relocOffset+1, 0xaa, 0, nil), // one byte short of fitting the 16-bit patch
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+			wantErr: ErrPeRelocOutOfBound,
+		},
+		{
+			name:    "HIGHLOW relocation address out of bounds",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_HIGHLOW, 0)),
+			delta:   0x10,
+			data:    paddedData(relocOffset+3, 0xaa, 0, nil), // one byte short of fitting the 32-bit patch
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+			wantErr: ErrPeRelocOutOfBound,
+		},
+		{
+			name:    "DIR64 relocation address out of bounds",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_DIR64, 0)),
+			delta:   0x10,
+			data:    paddedData(relocOffset+7, 0xaa, 0, nil), // one byte short of fitting the 64-bit patch
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+			wantErr: ErrPeRelocOutOfBound,
+		},
+		{
+			name:    "ARM_MOV32 relocation address out of bounds",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_ARM_MOV32, 0)),
+			delta:   0x10000,
+			data:    paddedData(relocOffset+7, 0xaa, 0, nil), // needs 8 bytes (movw+movt), has 7
+			machine: pe.IMAGE_FILE_MACHINE_ARMNT,
+			wantErr: ErrPeRelocOutOfBound,
+		},
+		{
+			name:    "ARM64_BRANCH26 relocation address out of bounds",
+			relocs:  relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_ARM64_BRANCH26, 0)),
+			delta:   16,
+			data:    paddedData(relocOffset+3, 0xaa, 0, nil), // one byte short of fitting the 32-bit instruction
+			machine: pe.IMAGE_FILE_MACHINE_ARM64,
+			wantErr: ErrPeRelocOutOfBound,
+		},
+		{
+			// HIGHADJ is the last entry of its block, with no paired adjust
+			// entry following it in the stream. relocatePE must report an
+			// out-of-bounds error rather than reading the next block's
+			// PageRVA/BlockSize header bytes as the adjust value.
+			name: "HIGHADJ straddling a block boundary",
+			relocs: append(
+				relocBlock(relocOffset, relocEntry(IMAGE_REL_BASED_HIGHADJ, 0)),
+				relocBlock(0x20, relocEntry(IMAGE_REL_BASED_ABSOLUTE, 0))...,
+			),
+			delta:   0x10000,
+			data:    paddedData(bufSize, 0xaa, relocOffset, leUint16(0x0010)),
+			machine: pe.IMAGE_FILE_MACHINE_AMD64,
+			wantErr: ErrPeRelocOutOfBound,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte(nil), tt.data...)
+			err := relocatePE(tt.relocs, tt.delta, data, tt.machine)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("relocatePE() err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("relocatePE(): %v", err)
+			}
+			if !bytes.Equal(data, tt.want) {
+				t.Errorf("relocatePE() data = %x, want %x", data, tt.want)
+			}
+		})
+	}
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func leUint16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func leUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func leUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}